@@ -123,15 +123,9 @@ func verifyToken(token string) (username string, ok bool) {
 		return "", false
 	}
 
-	expiry, ok := payload.Values[1].(*wire.String)
+	expiry, ok := payload.Values[1].(*wire.Timestamp)
 
-	if !ok {
-		return "", false
-	}
-
-	date, err := time.Parse(time.RFC3339Nano, expiry.Value)
-
-	if err != nil || time.Now().After(date) {
+	if !ok || time.Now().After(expiry.Value) {
 		return "", false
 	}
 
@@ -15,7 +15,7 @@ func handleClose(args []wire.Value, s *sessionInfo) wire.Value {
 		return wire.NewError("ARG", "Command CLOSE expects an integer as first argument")
 	}
 
-	ok = s.session.CloseStream(streamId.Value)
+	ok = s.session.CloseStream(int(streamId.Value))
 
 	if !ok {
 		return wire.NewError("ARG", "Stream is already closed")
@@ -62,5 +62,5 @@ func handleCopy(args []wire.Value, s *sessionInfo) wire.Value {
 		return wireErr
 	}
 
-	return wire.NewInteger(id)
+	return wire.NewInteger(int64(id))
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+func handleCredit(args []wire.Value, s *sessionInfo) wire.Value {
+	if len(args) != 2 {
+		return wire.NewError("ARG", "Command CREDIT expects exactly 2 arguments")
+	}
+
+	streamId, ok := args[0].(*wire.Integer)
+
+	if !ok {
+		return wire.NewError("ARG", "Stream ID should be an integer, got %s", args[0].Name())
+	}
+
+	credit, ok := args[1].(*wire.Integer)
+
+	if !ok {
+		return wire.NewError("ARG", "Credit should be an integer, got %s", args[1].Name())
+	}
+
+	if credit.Value <= 0 {
+		return wire.NewError("ARG", "Credit should be positive")
+	}
+
+	if !s.session.AddCredit(int(streamId.Value), int(credit.Value)) {
+		return wire.NewError("ARG", "Stream is already closed")
+	}
+
+	return wire.OK
+}
@@ -0,0 +1,48 @@
+require 'securerandom'
+
+RSpec.describe 'CREDIT' do
+    before(:all) do
+        @filename = "credit-#{SecureRandom.hex}.txt"
+        admin.write_file(@filename, "hello\nworld\n")
+        admin.cmd!('WINDOW', 6)
+    end
+
+    after(:all) do
+        admin.cmd!('WINDOW', 0)
+    end
+
+    it 'resumes stream once credit is granted' do
+        id = admin.cmd!('STREAM', 'R', @filename).value
+
+        resp = admin.get_frame(id)
+        expect(resp.payload).to be_a(Wire::Blob)
+        expect(resp.payload.value).to eq("hello\n")
+
+        admin.buffer do |b|
+            b.put_array('CREDIT', Wire::Integer.new(id), Wire::Integer.new(100))
+        end
+
+        # The stream may resume before the response is sent
+        responses = 3.times.map { admin.get_next }
+        frames = responses.select { |r| r.is_a? Wire::Frame }
+        ok = responses.find { |r| r.is_a? Wire::String }
+
+        expect(ok.value).to eq('OK')
+        expect(frames.length).to eq(2)
+        expect(frames[0].payload).to be_a(Wire::Blob)
+        expect(frames[0].payload.value).to eq("world\n")
+        expect(frames[1].payload).to be_a(Wire::Null)
+    end
+
+    it 'returns ARG for closed stream' do
+        resp = admin.cmd('CREDIT', 100000, 100)
+        expect(resp).to be_error('ARG')
+    end
+
+    it 'returns ARG for non-positive credit' do
+        id = admin.cmd!('STREAM', 'R', @filename).value
+        resp = admin.cmd('CREDIT', id, 0)
+        expect(resp).to be_error('ARG')
+        admin.cmd!('CLOSE', id)
+    end
+end
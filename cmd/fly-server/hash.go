@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/ngagnon/flybywire/internal/digest"
+	log "github.com/ngagnon/flybywire/internal/logging"
+	"github.com/ngagnon/flybywire/internal/vfs"
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+func handleHash(args []wire.Value, s *sessionInfo) wire.Value {
+	if len(args) == 0 {
+		return wire.NewError("ARG", "Command HASH expects at least one argument")
+	}
+
+	rawPath, ok := args[0].(*wire.String)
+
+	if !ok {
+		return wire.NewError("ARG", "Path should be a string, got %s", args[0].Name())
+	}
+
+	opts, wireErr := parseOptions(args[1:], "ALGORITHM")
+
+	if wireErr != nil {
+		return wireErr
+	}
+
+	algorithm := digest.Default
+
+	if val, ok := opts["ALGORITHM"]; ok {
+		name, ok := val.(*wire.String)
+
+		if !ok {
+			return wire.NewError("ARG", "Algorithm should be a string, got %s", val.Name())
+		}
+
+		algorithm = strings.ToUpper(name.Value)
+	}
+
+	if !digest.Supported(algorithm) {
+		return wire.NewError("ARG", "Unsupported algorithm: %s", algorithm)
+	}
+
+	vPath := "/" + strings.Trim(rawPath.Value, "/")
+	realPath, err := resolveRead(s, vPath)
+
+	if errors.Is(err, vfs.ErrDenied) {
+		return wire.NewError("DENIED", "Access denied")
+	}
+
+	if errors.Is(err, vfs.ErrInvalid) || errors.Is(err, vfs.ErrReserved) {
+		return wire.NewError("NOTFOUND", "No such file or directory")
+	}
+
+	info, err := os.Stat(realPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return wire.NewError("NOTFOUND", "No such file or directory")
+	}
+
+	if err != nil {
+		log.Debugf("Could not stat file: %v", err)
+		return wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	table := &wire.Table{}
+
+	if !info.IsDir() {
+		if !info.Mode().IsRegular() {
+			return wire.NewError("ARG", "Path should be a regular file or a folder")
+		}
+
+		if wireErr := addDigest(table, realPath, info.Name(), algorithm); wireErr != nil {
+			return wireErr
+		}
+
+		return table
+	}
+
+	files, err := os.ReadDir(realPath)
+
+	if err != nil {
+		log.Debugf("Could not read directory: %v", err)
+		return wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	for _, file := range files {
+		if !file.Type().IsRegular() {
+			continue
+		}
+
+		fullPath := path.Join(vPath, file.Name())
+
+		if _, err := resolveRead(s, fullPath); err != nil {
+			continue
+		}
+
+		if wireErr := addDigest(table, path.Join(realPath, file.Name()), file.Name(), algorithm); wireErr != nil {
+			return wireErr
+		}
+	}
+
+	return table
+}
+
+func addDigest(t *wire.Table, realPath string, name string, algorithm string) *wire.Error {
+	f, err := os.Open(realPath)
+
+	if err != nil {
+		log.Debugf("Could not open file for hashing: %v", err)
+		return wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	defer f.Close()
+
+	sum, err := digest.Sum(f, algorithm)
+
+	if err != nil {
+		log.Debugf("Could not hash file: %v", err)
+		return wire.NewError("IO", "Could not read file")
+	}
+
+	t.Add([]wire.Value{
+		wire.NewString(name),
+		wire.NewString(sum),
+	})
+
+	return nil
+}
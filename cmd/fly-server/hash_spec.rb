@@ -0,0 +1,78 @@
+require 'securerandom'
+require 'digest'
+
+RSpec.describe 'HASH' do
+    context 'unauthorized' do
+        it 'returns DENIED' do
+            filename = "hash-#{SecureRandom.hex}.txt"
+            admin.write_file(filename, "hello\nworld\n")
+            resp = unauth.cmd('HASH', filename)
+            expect(resp).to be_error('DENIED')
+        end
+    end
+
+    context 'authorized' do
+        ['admin', 'regular user', 'single user'].each do |persona|
+            context "as #{persona}" do
+                before(:all) do
+                    @session = as(persona)
+                    @folder = "hash-#{SecureRandom.hex}"
+                    @session.cmd!('MKDIR', @folder)
+                    @session.cmd!('MKDIR', "#{@folder}/subfolder")
+
+                    @files = {
+                        'file1.txt' => "hello\nworld\n",
+                        'file2.txt' => "hello\nworld\nfoo\nbar\n"
+                    }
+
+                    @files.each do |name, content|
+                        @session.write_file("#{@folder}/#{name}", content)
+                    end
+                end
+
+                it 'returns SHA-256 of file' do
+                    resp = @session.cmd('HASH', "#{@folder}/file1.txt")
+                    expect(resp).to be_a(Wire::Table)
+                    expect(resp.row_count).to eq(1)
+                    expect(resp.col_count).to eq(2)
+                    expect(resp[0][0].value).to eq('file1.txt')
+                    expect(resp[0][1].value).to eq(Digest::SHA256.hexdigest(@files['file1.txt']))
+                end
+
+                it 'returns BLAKE2b of file' do
+                    resp = @session.cmd('HASH', "#{@folder}/file1.txt", 'ALGORITHM', 'BLAKE2B')
+                    expect(resp).to be_a(Wire::Table)
+                    expect(resp.row_count).to eq(1)
+                    expect(resp[0][1].value.length).to eq(128)
+                end
+
+                it 'returns xxhash of file' do
+                    resp = @session.cmd('HASH', "#{@folder}/file1.txt", 'ALGORITHM', 'XXHASH')
+                    expect(resp).to be_a(Wire::Table)
+                    expect(resp.row_count).to eq(1)
+                    expect(resp[0][1].value.length).to eq(16)
+                end
+
+                it 'returns digest of each file in folder' do
+                    resp = @session.cmd('HASH', @folder)
+                    expect(resp).to be_a(Wire::Table)
+                    expect(resp.row_count).to eq(2)
+
+                    resp.each do |row|
+                        expect(row[1].value).to eq(Digest::SHA256.hexdigest(@files[row[0].value]))
+                    end
+                end
+
+                it 'returns ARG for unsupported algorithm' do
+                    resp = @session.cmd('HASH', "#{@folder}/file1.txt", 'ALGORITHM', 'MD4')
+                    expect(resp).to be_error('ARG')
+                end
+
+                it 'returns NOTFOUND when file does not exist' do
+                    resp = @session.cmd('HASH', "#{@folder}/#{SecureRandom.hex}.txt")
+                    expect(resp).to be_error('NOTFOUND')
+                end
+            end
+        end
+    end
+end
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ngagnon/flybywire/internal/session"
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+const protocolVersion = 1
+
+// Optional features that aren't covered by the list of commands
+var extensions = []string{
+	"CHECKSUM",
+	"COMPRESS",
+	"DELTA",
+	"RANGE",
+	"RESUME",
+	"STREAMING",
+	"TAGS",
+	"TIMESTAMPS",
+}
+
+// Can't be computed from handleHello directly, since commandHandlers refers to it
+var commandList []string
+
+func init() {
+	commandList = commandNames()
+}
+
+func handleHello(args []wire.Value, s *sessionInfo) wire.Value {
+	if len(args) == 0 || len(args) > 2 {
+		return wire.NewError("ARG", "Command HELLO expects 1 or 2 arguments")
+	}
+
+	version, ok := args[0].(*wire.Integer)
+
+	if !ok {
+		return wire.NewError("ARG", "Version should be an integer, got %s", args[0].Name())
+	}
+
+	if version.Value < 1 {
+		return wire.NewError("ARG", "Unsupported protocol version: %d", version.Value)
+	}
+
+	requested := []string{}
+
+	if len(args) == 2 {
+		arr, ok := args[1].(*wire.Array)
+
+		if !ok {
+			return wire.NewError("ARG", "Extensions should be an array, got %s", args[1].Name())
+		}
+
+		for _, v := range arr.Values {
+			name, ok := v.(*wire.String)
+
+			if !ok {
+				return wire.NewError("ARG", "Extension name should be a string, got %s", v.Name())
+			}
+
+			requested = append(requested, strings.ToUpper(name.Value))
+		}
+	}
+
+	// Only the extensions supported on both sides are enabled
+	enabled := make([]string, 0, len(extensions))
+
+	for _, ext := range extensions {
+		if isAllowedOption(ext, requested) {
+			enabled = append(enabled, ext)
+		}
+	}
+
+	s.session.SetExtensions(enabled)
+
+	result := make(map[string]wire.Value)
+	result["version"] = wire.NewInteger(protocolVersion)
+	result["commands"] = stringArray(commandList)
+	result["extensions"] = stringArray(enabled)
+	result["maxblob"] = wire.NewInteger(session.MaxBlobSize)
+	result["maxstreams"] = wire.NewInteger(int64(s.session.MaxStreams()))
+
+	return wire.NewMap(result)
+}
+
+func commandNames() []string {
+	// QUIT is handled by the session itself
+	names := []string{"QUIT"}
+
+	for name := range commandHandlers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func stringArray(values []string) *wire.Array {
+	arr := make([]wire.Value, len(values))
+
+	for i, v := range values {
+		arr[i] = wire.NewString(v)
+	}
+
+	return wire.NewArray(arr)
+}
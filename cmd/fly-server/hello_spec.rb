@@ -0,0 +1,47 @@
+RSpec.describe 'HELLO' do
+    context 'without extensions' do
+        before(:all) do
+            @resp = unauth.cmd('HELLO', 1)
+        end
+
+        it 'returns server capabilities' do
+            expect(@resp).to be_a(Wire::Map)
+            expect(@resp.keys).to match_array(['version', 'commands', 'extensions', 'maxblob', 'maxstreams'])
+            expect(@resp['version']).to be_a(Wire::Integer)
+            expect(@resp['version'].value).to eq(1)
+            expect(@resp['maxblob']).to be_a(Wire::Integer)
+            expect(@resp['maxblob'].value).to eq(32 * 1024)
+            expect(@resp['maxstreams']).to be_a(Wire::Integer)
+        end
+
+        it 'lists supported commands' do
+            expect(@resp['commands']).to be_a(Wire::Array)
+
+            commands = @resp['commands'].elems.map { |c| c.value }
+            expect(commands).to include('HELLO', 'LIST', 'STREAM', 'QUIT')
+        end
+
+        it 'does not enable any extension' do
+            expect(@resp['extensions']).to be_a(Wire::Array)
+            expect(@resp['extensions'].elems).to be_empty
+        end
+    end
+
+    context 'with extensions' do
+        it 'enables extensions supported by both sides' do
+            resp = unauth.cmd('HELLO', 1, ['tags', 'RESUME', 'FOOBAR'])
+            expect(resp).to be_a(Wire::Map)
+
+            extensions = resp['extensions'].elems.map { |e| e.value }
+            expect(extensions).to match_array(['RESUME', 'TAGS'])
+        end
+    end
+
+    it 'returns ARG for invalid version' do
+        resp = unauth.cmd('HELLO', 0)
+        expect(resp).to be_error('ARG')
+
+        resp = unauth.cmd('HELLO', 'one')
+        expect(resp).to be_error('ARG')
+    end
+end
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"io"
+	"io/fs"
 	"os"
 	"path"
 	"strings"
 	"time"
 
 	log "github.com/ngagnon/flybywire/internal/logging"
+	"github.com/ngagnon/flybywire/internal/session"
 	"github.com/ngagnon/flybywire/internal/vfs"
 	"github.com/ngagnon/flybywire/internal/wire"
 )
@@ -45,38 +49,136 @@ func handleList(args []wire.Value, s *sessionInfo) wire.Value {
 		return wire.NewError("ERR", "Unexpected error occurred")
 	}
 
-	table := &wire.Table{}
+	if !info.IsDir() {
+		table := &wire.Table{}
 
-	if info.IsDir() {
-		files, err := os.ReadDir(realPath)
+		if row := fileRow(info, s); row != nil {
+			table.Add(row)
+		}
+
+		return table
+	}
+
+	if s.session.HasExtension("STREAMING") {
+		dir, err := os.Open(realPath)
 
 		if err != nil {
-			log.Debugf("Could not read directory: %v", err)
+			log.Debugf("Could not open directory: %v", err)
 			return wire.NewError("ERR", "Unexpected error occurred")
 		}
 
-		for _, file := range files {
-			info, err := file.Info()
+		resp := s.session.NewResponse()
+		go listDir(dir, vPath, resp, s)
+
+		return resp
+	}
+
+	files, err := os.ReadDir(realPath)
+
+	if err != nil {
+		log.Debugf("Could not read directory: %v", err)
+		return wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	rows, wireErr := dirRows(files, vPath, s)
+
+	if wireErr != nil {
+		return wireErr
+	}
+
+	table := &wire.Table{ColCount: 4}
+
+	for _, row := range rows {
+		table.Add(row)
+	}
+
+	return table
+}
+
+// Number of directory entries read at a time when streaming
+const listBatchSize = 256
+
+// Streams the rows of the folder as a table, while reading it. The rows are
+// sent in parts of about MaxBlobSize bytes, so that neither the folder nor its
+// encoding has to be held in memory, and the writer never waits on the disk.
+func listDir(dir *os.File, vPath string, resp *session.Response, s *sessionInfo) {
+	defer dir.Close()
+
+	var buf bytes.Buffer
+	e := wire.NewEncoder(&buf)
+	e.BeginTable(wire.Streamed, 4)
+
+	for {
+		entries, err := dir.ReadDir(listBatchSize)
+
+		if err == io.EOF {
+			e.End()
+			resp.End(buf.Bytes())
+			return
+		}
 
-			if err != nil {
-				log.Debugf("Could not get file info: %v", err)
-				return wire.NewError("ERR", "Unexpected error occurred")
+		if err != nil {
+			log.Debugf("Could not read directory: %v", err)
+			e.Abort(wire.NewError("ERR", "Unexpected error occurred"))
+			resp.End(buf.Bytes())
+			return
+		}
+
+		rows, wireErr := dirRows(entries, vPath, s)
+
+		if wireErr != nil {
+			e.Abort(wireErr)
+			resp.End(buf.Bytes())
+			return
+		}
+
+		for _, row := range rows {
+			for _, v := range row {
+				e.Encode(v)
 			}
 
-			fullPath := path.Join(vPath, info.Name())
+			if buf.Len() >= session.MaxBlobSize {
+				if !resp.Send(buf.Bytes()) {
+					return
+				}
 
-			if _, err := resolveRead(s, fullPath); err == nil {
-				addFile(table, info)
+				buf.Reset()
 			}
 		}
-	} else {
-		addFile(table, info)
 	}
+}
 
-	return table
+// Skips the entries the user can't see, as well as those that were deleted
+// since the folder was read
+func dirRows(entries []fs.DirEntry, vPath string, s *sessionInfo) ([][]wire.Value, *wire.Error) {
+	rows := make([][]wire.Value, 0, len(entries))
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			log.Debugf("Could not get file info: %v", err)
+			return nil, wire.NewError("ERR", "Unexpected error occurred")
+		}
+
+		if _, err := resolveRead(s, path.Join(vPath, info.Name())); err != nil {
+			continue
+		}
+
+		if row := fileRow(info, s); row != nil {
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, nil
 }
 
-func addFile(t *wire.Table, info os.FileInfo) {
+// Returns nil for anything other than regular files and folders
+func fileRow(info os.FileInfo, s *sessionInfo) []wire.Value {
 	var ftype string
 	var fsize wire.Value
 
@@ -85,15 +187,24 @@ func addFile(t *wire.Table, info os.FileInfo) {
 		fsize = wire.Null
 	} else if info.Mode().IsRegular() {
 		ftype = "F"
-		fsize = wire.NewInteger(int(info.Size()))
+		fsize = wire.NewInteger(info.Size())
 	} else {
-		return
+		return nil
 	}
 
-	t.Add([]wire.Value{
+	return []wire.Value{
 		wire.NewString(ftype),
 		wire.NewString(info.Name()),
 		fsize,
-		wire.NewString(info.ModTime().UTC().Format(time.RFC3339Nano)),
-	})
+		timeValue(info.ModTime(), s),
+	}
+}
+
+// Clients that didn't negotiate the TIMESTAMPS extension get an RFC 3339 string
+func timeValue(t time.Time, s *sessionInfo) wire.Value {
+	if s.session.HasExtension("TIMESTAMPS") {
+		return wire.NewTimestamp(t)
+	}
+
+	return wire.NewString(t.UTC().Format(time.RFC3339Nano))
 }
@@ -65,6 +65,75 @@ RSpec.describe 'LIST' do
                         expect(f[1].value).not_to eq('.fly')
                     end
                 end
+
+                it 'does not show uploads in progress' do
+                    id = @session.cmd!('STREAM', 'W', 'list-admin/uploading.txt').value
+                    @session.put_stream(id)
+                    @session.put_blob("hello\n")
+
+                    resp = @session.cmd('LIST', 'list-admin')
+                    expect(resp).to be_a(Wire::Table)
+                    expect(resp.row_count).to eq(4)
+
+                    resp.each do |f|
+                        expect(f[1].value).not_to include('uploading.txt')
+                    end
+
+                    @session.cmd!('CLOSE', id)
+                end
+
+                it 'returns file names containing line feeds' do
+                    folder = "list-#{SecureRandom.hex}"
+                    @session.cmd!('MKDIR', folder)
+                    @session.write_file("#{folder}/new\nline.txt", "hello\n")
+
+                    resp = @session.cmd('LIST', folder)
+                    expect(resp).to be_a(Wire::Table)
+                    expect(resp.row_count).to eq(1)
+                    expect(resp[0][1]).to be_a(Wire::String)
+                    expect(resp[0][1].value).to eq("new\nline.txt")
+
+                    resp = @session.cmd('LIST', "#{folder}/new\nline.txt")
+                    expect(resp).to be_a(Wire::Table)
+                    expect(resp.row_count).to eq(1)
+                    expect(resp[0][2].value).to eq(6)
+                end
+            end
+
+            describe 'streamed folder' do
+                before(:all) do
+                    @streaming = Session.new(label: "#{persona} (streaming)")
+
+                    if persona == 'admin'
+                        @streaming.cmd!('AUTH', 'PWD', 'example', 'supersecret')
+                    else
+                        @streaming.cmd!('AUTH', 'PWD', 'joe', 'regularguy')
+                    end
+
+                    resp = @streaming.cmd!('HELLO', 1, ['STREAMING'])
+                    expect(resp['extensions'].elems.map { |e| e.value }).to eq(['STREAMING'])
+                end
+
+                after(:all) do
+                    @streaming.close
+                end
+
+                it 'returns list of files' do
+                    resp = @streaming.cmd('LIST', 'list-admin')
+                    expect(resp).to be_a(Wire::Table)
+                    expect(resp.col_count).to eq(4)
+
+                    names = resp.rows.map { |row| row[1].value }
+                    expect(names).to include('file1.txt', 'file2.txt', 'file3.txt', 'folderthing')
+                    expect(names).not_to include(a_string_ending_with('.fly-upload'))
+                end
+
+                it 'returns file stats' do
+                    resp = @streaming.cmd('LIST', 'list-admin/file2.txt')
+                    expect(resp).to be_a(Wire::Table)
+                    expect(resp.row_count).to eq(1)
+                    expect(resp[0][1].value).to eq('file2.txt')
+                end
             end
 
             describe 'file' do
@@ -89,6 +158,34 @@ RSpec.describe 'LIST' do
                 end
             end
 
+            describe 'with timestamps' do
+                before(:all) do
+                    @typed = Session.new(label: "#{persona} (timestamps)")
+
+                    if persona == 'admin'
+                        @typed.cmd!('AUTH', 'PWD', 'example', 'supersecret')
+                    else
+                        @typed.cmd!('AUTH', 'PWD', 'joe', 'regularguy')
+                    end
+
+                    resp = @typed.cmd!('HELLO', 1, ['TIMESTAMPS'])
+                    expect(resp['extensions'].elems.map { |e| e.value }).to eq(['TIMESTAMPS'])
+                end
+
+                after(:all) do
+                    @typed.close
+                end
+
+                it 'returns last modified time as a timestamp' do
+                    resp = @typed.cmd('LIST', 'list-admin/file2.txt')
+                    expect(resp).to be_a(Wire::Table)
+                    expect(resp.row_count).to eq(1)
+
+                    expect(resp[0][3]).to be_a(Wire::Timestamp)
+                    expect(resp[0][3].value).to be_within(0.100).of(@files[1][:mtime])
+                end
+            end
+
             describe 'non existent path' do
                 it 'returns NOTFOUND' do
                     resp = @session.cmd('LIST', "/some/path/#{SecureRandom.hex}")
@@ -7,6 +7,8 @@ import (
 	"net"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ngagnon/flybywire/internal/crypto"
 	"github.com/ngagnon/flybywire/internal/db"
@@ -21,12 +23,15 @@ type sessionInfo struct {
 	user       *db.User
 	singleUser bool
 	session    *session.S
+	parent     *sessionInfo
+	lock       sync.Mutex
 }
 
 type commandHandler func(args []wire.Value, session *sessionInfo) (response wire.Value)
 
 var commandHandlers = map[string]commandHandler{
 	"PING":     handlePing,
+	"HELLO":    handleHello,
 	"WHOAMI":   handleWhoAmI,
 	"AUTH":     handleAuth,
 	"TOKEN":    handleToken,
@@ -35,6 +40,7 @@ var commandHandlers = map[string]commandHandler{
 	"DEL":      handleDel,
 	"MOVE":     handleMove,
 	"COPY":     handleCopy,
+	"HASH":     handleHash,
 	"LIST":     handleList,
 	"LISTUSER": handleListUser,
 	"ADDUSER":  handleAddUser,
@@ -44,7 +50,10 @@ var commandHandlers = map[string]commandHandler{
 	"RMUSER":   handleRmuser,
 	"SHOWUSER": handleShowUser,
 	"STREAM":   handleStream,
+	"SYNC":     handleSync,
 	"CLOSE":    handleClose,
+	"WINDOW":   handleWindow,
+	"CREDIT":   handleCredit,
 	"LISTACP":  handleListAcp,
 	"PUTACP":   handlePutAcp,
 	"RMACP":    handleRmAcp,
@@ -57,9 +66,11 @@ var flydb *db.Handle
 var tokenKey []byte
 
 var (
-	port  = flag.Int("port", 6767, "TCP port to listen on")
-	notls = flag.Bool("notls", false, "Disable TLS")
-	debug = flag.Bool("debug", false, "Turn on debug logging")
+	port             = flag.Int("port", 6767, "TCP port to listen on")
+	notls            = flag.Bool("notls", false, "Disable TLS")
+	debug            = flag.Bool("debug", false, "Turn on debug logging")
+	maxStreams       = flag.Int("maxstreams", session.DefaultMaxStreams, "Maximum number of open streams per session (0 for no limit)")
+	maxServerStreams = flag.Int("maxserverstreams", session.DefaultMaxServerStreams, "Maximum number of open streams for the whole server (0 for no limit)")
 )
 
 func main() {
@@ -73,6 +84,10 @@ func main() {
 		log.Fatalf("Usage: fly-server ROOTDIR")
 	}
 
+	if *maxStreams < 0 || *maxServerStreams < 0 {
+		log.Fatalf("Stream limits should not be negative")
+	}
+
 	dir = flag.Arg(0)
 
 	if stat, err := os.Stat(dir); os.IsNotExist(err) || !stat.IsDir() {
@@ -98,6 +113,9 @@ func main() {
 	defer ln.Close()
 
 	vfs.Setup(&policyStore{}, dir)
+	session.SetStreamLimits(*maxStreams, *maxServerStreams)
+	go session.RemoveStaleUploads(dir, time.Now())
+	go session.ExpireUploads()
 
 	tokenKey, err = crypto.RandomKey(16)
 
@@ -117,16 +135,12 @@ func main() {
 		s := &sessionInfo{}
 
 		go session.Handle(conn, func(cmd *wire.Array, session *session.S) (response wire.Value) {
-			if s.session == nil {
-				s.session = session
-			}
-
-			return dispatchCommand(cmd, s)
+			return dispatchCommand(cmd, session, s)
 		})
 	}
 }
 
-func dispatchCommand(cmd *wire.Array, s *sessionInfo) (response wire.Value) {
+func dispatchCommand(cmd *wire.Array, sess *session.S, s *sessionInfo) (response wire.Value) {
 	cmdName := cmd.Values[0].(*wire.String).Value
 	handler, ok := getCommandHandler(cmdName)
 
@@ -134,10 +148,23 @@ func dispatchCommand(cmd *wire.Array, s *sessionInfo) (response wire.Value) {
 		return wire.NewError("CMD", "Unknown command '%s'", cmdName)
 	}
 
+	// Tagged commands run concurrently, so each command gets its own copy of the session info
+	s.lock.Lock()
+	s.session = sess
 	s.update()
 
+	snapshot := &sessionInfo{
+		username:   s.username,
+		user:       s.user,
+		singleUser: s.singleUser,
+		session:    sess,
+		parent:     s,
+	}
+
+	s.lock.Unlock()
+
 	args := cmd.Values[1:]
-	return handler(args, s)
+	return handler(args, snapshot)
 }
 
 func (s *sessionInfo) update() {
@@ -173,6 +200,13 @@ func (s *sessionInfo) changeUser(username string) {
 	}
 
 	s.user = &user
+
+	if s.parent != nil {
+		s.parent.lock.Lock()
+		s.parent.username = s.username
+		s.parent.user = s.user
+		s.parent.lock.Unlock()
+	}
 }
 
 func getCommandHandler(s string) (h commandHandler, ok bool) {
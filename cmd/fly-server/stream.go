@@ -4,13 +4,14 @@ import (
 	"errors"
 	"strings"
 
+	"github.com/ngagnon/flybywire/internal/compress"
 	"github.com/ngagnon/flybywire/internal/vfs"
 	"github.com/ngagnon/flybywire/internal/wire"
 )
 
 func handleStream(args []wire.Value, s *sessionInfo) wire.Value {
-	if len(args) != 2 {
-		return wire.NewError("ARG", "Command STREAM expects exactly 2 arguments")
+	if len(args) < 2 {
+		return wire.NewError("ARG", "Command STREAM expects at least 2 arguments")
 	}
 
 	mode, ok := args[0].(*wire.String)
@@ -32,6 +33,25 @@ func handleStream(args []wire.Value, s *sessionInfo) wire.Value {
 	}
 
 	writing := mode.Value == "W"
+	var opts map[string]wire.Value
+	var wireErr *wire.Error
+
+	if writing {
+		opts, wireErr = parseOptions(args[2:], "RESUME", "COMPRESS", "DELTA")
+	} else {
+		opts, wireErr = parseOptions(args[2:], "OFFSET", "LENGTH", "COMPRESS")
+	}
+
+	if wireErr != nil {
+		return wireErr
+	}
+
+	compression, wireErr := compressionOption(opts)
+
+	if wireErr != nil {
+		return wireErr
+	}
+
 	realPath, err := resolve(s, vPath, writing)
 
 	if errors.Is(err, vfs.ErrDenied) {
@@ -43,20 +63,164 @@ func handleStream(args []wire.Value, s *sessionInfo) wire.Value {
 	}
 
 	if writing {
-		id, err := s.session.NewWriteStream(realPath)
+		resume, isResumable := opts["RESUME"]
+		_, isDelta := opts["DELTA"]
+
+		if isResumable && isDelta {
+			return wire.NewError("ARG", "Options RESUME and DELTA cannot be combined")
+		}
+
+		if isResumable {
+			return handleResumableStream(realPath, resume, compression, s)
+		}
+
+		if isDelta {
+			return handleDeltaStream(realPath, vPath, opts["DELTA"], compression, s)
+		}
+
+		id, err := s.session.NewWriteStream(realPath, compression)
 
 		if err != nil {
 			return err
 		}
 
-		return wire.NewInteger(id)
+		return wire.NewInteger(int64(id))
 	} else {
-		id, err := s.session.NewReadStream(realPath)
+		offset, wireErr := integerOption(opts, "OFFSET", 0)
+
+		if wireErr != nil {
+			return wireErr
+		}
+
+		length, wireErr := integerOption(opts, "LENGTH", -1)
+
+		if wireErr != nil {
+			return wireErr
+		}
+
+		id, err := s.session.NewReadStream(realPath, offset, length, compression)
 
 		if err != nil {
 			return err
 		}
 
-		return wire.NewInteger(id)
+		return wire.NewInteger(int64(id))
+	}
+}
+
+func handleResumableStream(realPath string, resume wire.Value, compression string, s *sessionInfo) wire.Value {
+	token, ok := resume.(*wire.String)
+
+	if !ok {
+		return wire.NewError("ARG", "Resume token should be a string, got %s", resume.Name())
+	}
+
+	id, newToken, offset, err := s.session.NewResumableWriteStream(realPath, token.Value, s.username, compression)
+
+	if err != nil {
+		return err
+	}
+
+	return wire.NewArray([]wire.Value{
+		wire.NewInteger(int64(id)),
+		wire.NewString(newToken),
+		wire.NewInteger(offset),
+	})
+}
+
+// Returns the compression algorithm requested by the client, or an empty string
+func compressionOption(opts map[string]wire.Value) (string, *wire.Error) {
+	val, ok := opts["COMPRESS"]
+
+	if !ok {
+		return "", nil
+	}
+
+	name, ok := val.(*wire.String)
+
+	if !ok {
+		return "", wire.NewError("ARG", "Compression should be a string, got %s", val.Name())
 	}
+
+	algorithm := strings.ToUpper(name.Value)
+
+	if !compress.Supported(algorithm) {
+		return "", wire.NewError("ARG", "Unsupported compression: %s", algorithm)
+	}
+
+	return algorithm, nil
+}
+
+// Parses optional arguments passed as NAME value pairs, or as a single map
+func parseOptions(args []wire.Value, allowed ...string) (map[string]wire.Value, *wire.Error) {
+	opts := make(map[string]wire.Value)
+
+	if len(args) == 1 {
+		if m, ok := args[0].(*wire.Map); ok {
+			for _, name := range m.Keys() {
+				key := strings.ToUpper(name)
+
+				if !isAllowedOption(key, allowed) {
+					return nil, wire.NewError("ARG", "Unsupported option: %s", name)
+				}
+
+				opts[key], _ = m.Get(name)
+			}
+
+			return opts, nil
+		}
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		name, ok := args[i].(*wire.String)
+
+		if !ok {
+			return nil, wire.NewError("ARG", "Option name should be a string, got %s", args[i].Name())
+		}
+
+		key := strings.ToUpper(name.Value)
+
+		if !isAllowedOption(key, allowed) {
+			return nil, wire.NewError("ARG", "Unsupported option: %s", name.Value)
+		}
+
+		if i+1 >= len(args) {
+			return nil, wire.NewError("ARG", "Option %s expects a value", name.Value)
+		}
+
+		opts[key] = args[i+1]
+	}
+
+	return opts, nil
+}
+
+// Returns the value of a non-negative integer option, or def if it wasn't passed
+func integerOption(opts map[string]wire.Value, name string, def int64) (int64, *wire.Error) {
+	val, ok := opts[name]
+
+	if !ok {
+		return def, nil
+	}
+
+	i, ok := val.(*wire.Integer)
+
+	if !ok {
+		return 0, wire.NewError("ARG", "Option %s should be an integer, got %s", name, val.Name())
+	}
+
+	if i.Value < 0 {
+		return 0, wire.NewError("ARG", "Option %s should not be negative", name)
+	}
+
+	return i.Value, nil
+}
+
+func isAllowedOption(name string, allowed []string) bool {
+	for _, a := range allowed {
+		if a == name {
+			return true
+		}
+	}
+
+	return false
 }
@@ -1,5 +1,7 @@
 require 'securerandom'
+require 'digest'
 require 'faker'
+require 'zlib'
 
 RSpec.describe 'STREAM' do
     ['admin', 'regular user'].each do |persona|
@@ -46,14 +48,13 @@ RSpec.describe 'STREAM' do
                     @session.put_stream(@id)
                     @session.put_null
 
-                    filepath = File.join($dir, 'test.txt')
-                    i = 10
-
-                    until File.exists?(filepath) || i == 0
-                        sleep 0.100
-                        i = i - 1
-                    end
+                    resp = @session.get_frame(@id)
+                    expect(resp.payload).to be_a(Wire::Array)
+                    expect(resp.payload.elems[0]).to be_a(Wire::Integer)
+                    expect(resp.payload.elems[0].value).to eq(21)
+                    expect(resp.payload.elems[1]).to be_a(Wire::String)
 
+                    filepath = File.join($dir, 'test.txt')
                     content = File.read(filepath)
                     expect(content).to eq "hello1\nhello2\nhello3\n"
                 end
@@ -82,14 +83,9 @@ RSpec.describe 'STREAM' do
                         @session.put_null
                     end
 
-                    50.times do
-                        resp = @session.cmd('LIST', 'write-2.txt')
-
-                        if resp.is_a? Wire::Error
-                            sleep 0.020
-                        else
-                            break
-                        end
+                    ids.each do |id|
+                        resp = @session.get_frame(id)
+                        expect(resp.payload).to be_a(Wire::Array)
                     end
 
                     3.times do |i|
@@ -98,12 +94,203 @@ RSpec.describe 'STREAM' do
                     end
                 end
 
+                it 'supports more than 16 concurrent streams' do
+                    ids = []
+
+                    50.times do |i|
+                        resp = @session.cmd('STREAM', 'W', "many-#{i}.txt")
+                        expect(resp).to be_a(Wire::Integer)
+                        ids.append(resp.value)
+                    end
+
+                    expect(ids.uniq.length).to eq(50)
+
+                    ids.each do |id|
+                        @session.put_stream(id)
+                        @session.put_null
+                    end
+
+                    ids.each do |id|
+                        resp = @session.get_frame(id)
+                        expect(resp.payload).to be_a(Wire::Array)
+                    end
+                end
+
+                it 'verifies checksum trailer' do
+                    id = @session.cmd!('STREAM', 'W', 'checksum.txt').value
+
+                    @session.put_stream(id)
+                    @session.put_blob("hello\n")
+                    @session.put_stream(id)
+                    @session.put_string("SHA256:#{Digest::SHA256.hexdigest("hello\n")}")
+
+                    resp = @session.get_frame(id)
+                    expect(resp.payload).to be_a(Wire::Array)
+
+                    contents = @session.read_file('checksum.txt')
+                    expect(contents).to eq("hello\n")
+                end
+
+                it 'discards file on checksum mismatch' do
+                    filename = "mismatch-#{SecureRandom.hex}.txt"
+                    id = @session.cmd!('STREAM', 'W', filename).value
+
+                    @session.put_stream(id)
+                    @session.put_blob("hello\n")
+                    @session.put_stream(id)
+                    @session.put_string("SHA256:#{Digest::SHA256.hexdigest("goodbye\n")}")
+
+                    resp = @session.get_next
+                    expect(resp).to be_a(Wire::Frame)
+                    expect(resp.id).to eq(id)
+                    expect(resp.payload).to be_error('CHECKSUM')
+
+                    resp = @session.cmd('LIST', filename)
+                    expect(resp).to be_error('NOTFOUND')
+                end
+
+                it 'discards staged file when it cannot replace the destination' do
+                    filename = "rename-#{SecureRandom.hex}.txt"
+                    id = @session.cmd!('STREAM', 'W', filename).value
+                    @session.cmd!('MKDIR', filename)
+
+                    @session.put_stream(id)
+                    @session.put_blob("hello\n")
+                    @session.put_stream(id)
+                    @session.put_null
+
+                    resp = @session.get_frame(id)
+                    expect(resp.payload).to be_error('IO')
+
+                    staged = Dir.glob(File.join($dir, ".#{filename}.*"))
+                    expect(staged).to be_empty
+                end
+
+                it 'rejects unsupported checksum algorithm' do
+                    id = @session.cmd!('STREAM', 'W', "algo-#{SecureRandom.hex}.txt").value
+
+                    @session.put_stream(id)
+                    @session.put_blob("hello\n")
+                    @session.put_stream(id)
+                    @session.put_string("MD5:b1946ac92492d2347c6235b4d2611184")
+
+                    resp = @session.get_next
+                    expect(resp).to be_a(Wire::Frame)
+                    expect(resp.id).to eq(id)
+                    expect(resp.payload).to be_error('ARG')
+                end
+
+                it 'decompresses chunks' do
+                    filename = "compressed-#{SecureRandom.hex}.txt"
+                    id = @session.cmd!('STREAM', 'W', filename, 'COMPRESS', 'gzip').value
+
+                    @session.put_stream(id)
+                    @session.put_blob(Zlib.gzip("hello1\n" * 100))
+                    @session.put_stream(id)
+                    @session.put_blob(Zlib.gzip("hello2\n"))
+                    @session.put_stream(id)
+                    @session.put_null
+
+                    resp = @session.get_frame(id)
+                    expect(resp.payload).to be_a(Wire::Array)
+                    expect(resp.payload.elems[0].value).to eq(707)
+
+                    contents = @session.read_file(filename)
+                    expect(contents).to eq(("hello1\n" * 100) + "hello2\n")
+                end
+
+                it 'discards file when chunk cannot be decompressed' do
+                    filename = "corrupt-#{SecureRandom.hex}.txt"
+                    id = @session.cmd!('STREAM', 'W', filename, 'COMPRESS', 'gzip').value
+
+                    @session.put_stream(id)
+                    @session.put_blob("hello\n")
+
+                    resp = @session.get_frame(id)
+                    expect(resp.payload).to be_error('ARG')
+
+                    resp = @session.cmd('LIST', filename)
+                    expect(resp).to be_error('NOTFOUND')
+                end
+
+                it 'returns ARG for unsupported compression' do
+                    resp = @session.cmd('STREAM', 'W', 'test.txt', 'COMPRESS', 'zstd')
+                    expect(resp).to be_error('ARG')
+                end
+
                 it 'returns NOTFOUND when parent folder does not exist' do
                     resp = @session.cmd('STREAM', 'W', "/home/#{SecureRandom.hex}/test.txt")
                     expect(resp).to be_error('NOTFOUND')
                 end
             end
 
+            describe 'resumable write' do
+                before(:all) do
+                    @session = as(persona)
+                    @filename = "resume-#{SecureRandom.hex}.txt"
+                    @resp = @session.cmd('STREAM', 'W', @filename, 'RESUME', '')
+                end
+
+                it 'returns stream id, token and offset' do
+                    expect(@resp).to be_a(Wire::Array)
+                    expect(@resp.elems[0]).to be_a(Wire::Integer)
+                    expect(@resp.elems[1]).to be_a(Wire::String)
+                    expect(@resp.elems[2]).to be_a(Wire::Integer)
+                    expect(@resp.elems[2].value).to eq(0)
+                end
+
+                it 'resumes interrupted upload' do
+                    id = @resp.elems[0].value
+                    token = @resp.elems[1].value
+
+                    @session.put_stream(id)
+                    @session.put_blob("hello1\n")
+                    @session.cmd!('CLOSE', id)
+
+                    resp = @session.cmd!('STREAM', 'W', @filename, 'RESUME', token)
+                    expect(resp.elems[1].value).to eq(token)
+                    expect(resp.elems[2].value).to eq(7)
+                    id = resp.elems[0].value
+
+                    @session.put_stream(id)
+                    @session.put_blob("hello2\n")
+                    @session.put_stream(id)
+                    @session.put_null
+
+                    resp = @session.get_frame(id)
+                    expect(resp.payload).to be_a(Wire::Array)
+                    expect(resp.payload.elems[0].value).to eq(14)
+
+                    contents = @session.read_file(@filename)
+                    expect(contents).to eq("hello1\nhello2\n")
+                end
+
+                it 'returns NOTFOUND for unknown token' do
+                    resp = @session.cmd('STREAM', 'W', @filename, 'RESUME', SecureRandom.hex)
+                    expect(resp).to be_error('NOTFOUND')
+                end
+
+                it 'returns NOTFOUND for token of another user' do
+                    filename = "resume-#{SecureRandom.hex}.txt"
+                    resp = @session.cmd!('STREAM', 'W', filename, 'RESUME', '')
+                    @session.cmd!('CLOSE', resp.elems[0].value)
+
+                    other = persona == 'admin' ? regular_user : admin
+                    resp = other.cmd('STREAM', 'W', filename, 'RESUME', resp.elems[1].value)
+                    expect(resp).to be_error('NOTFOUND')
+                end
+
+                it 'returns ARG for unknown option' do
+                    resp = @session.cmd('STREAM', 'W', @filename, 'FOOBAR', '')
+                    expect(resp).to be_error('ARG')
+                end
+
+                it 'returns ARG when combined with DELTA' do
+                    resp = @session.cmd('STREAM', 'W', @filename, 'RESUME', '', 'DELTA', 1024)
+                    expect(resp).to be_error('ARG')
+                end
+            end
+
             describe 'read' do
                 before(:all) do
                     admin.write_file('test-read.txt', "hello1\nhello2\nhello3\nfoobar\n")
@@ -199,6 +386,73 @@ RSpec.describe 'STREAM' do
                     expect(contents2 == data2).to be(true)
                 end
 
+                it 'reads from offset' do
+                    id = @session.cmd!('STREAM', 'R', 'test-read.txt', 'OFFSET', 7).value
+
+                    resp = @session.get_next
+                    expect(resp).to be_a(Wire::Frame)
+                    expect(resp.id).to eq(id)
+                    expect(resp.payload).to be_a(Wire::Blob)
+                    expect(resp.payload.value).to eq("hello2\nhello3\nfoobar\n")
+
+                    resp = @session.get_next
+                    expect(resp.payload).to be_a(Wire::Null)
+                end
+
+                it 'reads range' do
+                    id = @session.cmd!('STREAM', 'R', 'test-read.txt', 'OFFSET', 7, 'LENGTH', 6).value
+
+                    resp = @session.get_next
+                    expect(resp).to be_a(Wire::Frame)
+                    expect(resp.id).to eq(id)
+                    expect(resp.payload).to be_a(Wire::Blob)
+                    expect(resp.payload.value).to eq("hello2")
+
+                    resp = @session.get_next
+                    expect(resp.payload).to be_a(Wire::Null)
+                end
+
+                it 'accepts options as a map' do
+                    id = @session.cmd!('STREAM', 'R', 'test-read.txt', { 'offset' => 7, 'LENGTH' => 6 }).value
+
+                    resp = @session.get_next
+                    expect(resp).to be_a(Wire::Frame)
+                    expect(resp.id).to eq(id)
+                    expect(resp.payload).to be_a(Wire::Blob)
+                    expect(resp.payload.value).to eq("hello2")
+
+                    resp = @session.get_next
+                    expect(resp.payload).to be_a(Wire::Null)
+                end
+
+                it 'returns ARG for unknown option in map' do
+                    resp = @session.cmd('STREAM', 'R', 'test-read.txt', { 'RESUME' => '' })
+                    expect(resp).to be_error('ARG')
+                end
+
+                it 'compresses chunks' do
+                    id = @session.cmd!('STREAM', 'R', 'test-read.txt', 'OFFSET', 7, 'COMPRESS', 'gzip').value
+
+                    resp = @session.get_next
+                    expect(resp).to be_a(Wire::Frame)
+                    expect(resp.id).to eq(id)
+                    expect(resp.payload).to be_a(Wire::Blob)
+                    expect(Zlib.gunzip(resp.payload.value)).to eq("hello2\nhello3\nfoobar\n")
+
+                    resp = @session.get_next
+                    expect(resp.payload).to be_a(Wire::Null)
+                end
+
+                it 'returns ARG for unsupported compression' do
+                    resp = @session.cmd('STREAM', 'R', 'test-read.txt', 'COMPRESS', 'zstd')
+                    expect(resp).to be_error('ARG')
+                end
+
+                it 'returns ARG for negative offset' do
+                    resp = @session.cmd('STREAM', 'R', 'test-read.txt', 'OFFSET', -1)
+                    expect(resp).to be_error('ARG')
+                end
+
                 it 'returns NOTFOUND when file does not exist' do
                     resp = @session.cmd('STREAM', 'R', 'test-not-exist.txt')
                     expect(resp).to be_a(Wire::Error)
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/ngagnon/flybywire/internal/delta"
+	log "github.com/ngagnon/flybywire/internal/logging"
+	"github.com/ngagnon/flybywire/internal/vfs"
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+const maxSyncBlockSize = 1024 * 1024
+
+func handleSync(args []wire.Value, s *sessionInfo) wire.Value {
+	if len(args) != 3 {
+		return wire.NewError("ARG", "Command SYNC expects exactly 3 arguments")
+	}
+
+	rawPath, ok := args[0].(*wire.String)
+
+	if !ok {
+		return wire.NewError("ARG", "Path should be a string, got %s", args[0].Name())
+	}
+
+	blockSize, wireErr := parseBlockSize(args[1])
+
+	if wireErr != nil {
+		return wireErr
+	}
+
+	table, ok := args[2].(*wire.Table)
+
+	if !ok {
+		return wire.NewError("ARG", "Signatures should be a table, got %s", args[2].Name())
+	}
+
+	if table.RowCount > 0 && table.ColCount != 2 {
+		return wire.NewError("ARG", "Signatures table should have 2 columns, got %d", table.ColCount)
+	}
+
+	sigs := make([]delta.Signature, 0, table.RowCount)
+
+	for i := 0; i < table.RowCount; i++ {
+		row := table.Row(i)
+		weak, ok := row[0].(*wire.Integer)
+
+		if !ok {
+			return wire.NewError("ARG", "Weak checksum should be an integer, got %s", row[0].Name())
+		}
+
+		strong, ok := row[1].(*wire.Blob)
+
+		if !ok {
+			return wire.NewError("ARG", "Strong checksum should be a blob, got %s", row[1].Name())
+		}
+
+		if len(strong.Data) != delta.StrongSize {
+			return wire.NewError("ARG", "Strong checksum should be %d bytes long", delta.StrongSize)
+		}
+
+		sig := delta.Signature{Weak: uint32(weak.Value)}
+		copy(sig.Strong[:], strong.Data)
+		sigs = append(sigs, sig)
+	}
+
+	vPath := "/" + strings.Trim(rawPath.Value, "/")
+	realPath, err := resolveRead(s, vPath)
+
+	if errors.Is(err, vfs.ErrDenied) {
+		return wire.NewError("DENIED", "Access denied")
+	}
+
+	if errors.Is(err, vfs.ErrInvalid) || errors.Is(err, vfs.ErrReserved) {
+		return wire.NewError("NOTFOUND", "No such file or directory")
+	}
+
+	info, err := os.Stat(realPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return wire.NewError("NOTFOUND", "No such file or directory")
+	}
+
+	if err != nil {
+		log.Debugf("Could not stat a file: %v", err)
+		return wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	if !info.Mode().IsRegular() {
+		return wire.NewError("ARG", "Path should be a regular file")
+	}
+
+	id, wireErr := s.session.NewSyncStream(realPath, blockSize, sigs)
+
+	if wireErr != nil {
+		return wireErr
+	}
+
+	return wire.NewInteger(int64(id))
+}
+
+// Opens a write stream for STREAM W with the DELTA option, which is SYNC the
+// other way around: the server sends the signatures of its copy of the file,
+// and the client sends the new version as block references and literal data.
+func handleDeltaStream(realPath string, vPath string, blockSize wire.Value, compression string, s *sessionInfo) wire.Value {
+	size, wireErr := parseBlockSize(blockSize)
+
+	if wireErr != nil {
+		return wireErr
+	}
+
+	// The signatures give the contents of the file away, so users who can't read
+	// the file get none, and have to send the whole file
+	basePath, err := resolveRead(s, vPath)
+
+	if err != nil {
+		basePath = ""
+	}
+
+	id, sigs, wireErr := s.session.NewDeltaWriteStream(realPath, basePath, size, compression)
+
+	if wireErr != nil {
+		return wireErr
+	}
+
+	table := &wire.Table{ColCount: 2}
+
+	for i := range sigs {
+		table.Add([]wire.Value{
+			wire.NewInteger(int64(sigs[i].Weak)),
+			wire.NewBlob(sigs[i].Strong[:]),
+		})
+	}
+
+	return wire.NewArray([]wire.Value{wire.NewInteger(int64(id)), table})
+}
+
+func parseBlockSize(val wire.Value) (int, *wire.Error) {
+	blockSize, ok := val.(*wire.Integer)
+
+	if !ok {
+		return 0, wire.NewError("ARG", "Block size should be an integer, got %s", val.Name())
+	}
+
+	if blockSize.Value <= 0 || blockSize.Value > maxSyncBlockSize {
+		return 0, wire.NewError("ARG", "Block size should be between 1 and %d", maxSyncBlockSize)
+	}
+
+	return int(blockSize.Value), nil
+}
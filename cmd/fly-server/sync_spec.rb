@@ -0,0 +1,175 @@
+require 'securerandom'
+require 'digest'
+
+RSpec.describe 'SYNC' do
+    def weak_sum(block)
+        a = 0
+        b = 0
+        len = block.bytesize
+
+        block.each_byte.with_index do |c, i|
+            a += c
+            b += (len - i) * c
+        end
+
+        (a & 0xffff) | ((b & 0xffff) << 16)
+    end
+
+    def blocks(data, block_size)
+        (0...data.bytesize).step(block_size).map { |i| data.byteslice(i, block_size) }
+    end
+
+    def signatures(data, block_size)
+        blocks = blocks(data, block_size)
+        table = Wire::Table.new(blocks.length, 2)
+
+        blocks.each do |block|
+            table.push([Wire::Integer.new(weak_sum(block)), Wire::Blob.new(Digest::SHA256.digest(block))])
+        end
+
+        table
+    end
+
+    def sync(session, id, old, block_size)
+        old_blocks = blocks(old, block_size)
+        contents = ''
+        literal = 0
+
+        loop do
+            resp = session.get_next
+
+            if !(resp.is_a? Wire::Frame)
+                raise 'response was expected to be a stream frame'
+            end
+
+            if resp.id != id
+                raise "unexpected frame id #{resp.id}"
+            end
+
+            payload = resp.payload
+
+            if payload.is_a? Wire::Null
+                return [contents, literal]
+            elsif payload.is_a? Wire::Integer
+                contents << old_blocks[payload.value]
+            elsif payload.is_a? Wire::Blob
+                contents << payload.value
+                literal += payload.value.length
+            else
+                raise "unexpected stream payload #{payload.class}"
+            end
+        end
+    end
+
+    context 'unauthorized' do
+        it 'returns DENIED' do
+            filename = "sync-#{SecureRandom.hex}.txt"
+            admin.write_file(filename, "hello\nworld\n")
+            resp = unauth.cmd('SYNC', filename, 1024, signatures('', 1024))
+            expect(resp).to be_error('DENIED')
+        end
+    end
+
+    context 'authorized' do
+        ['admin', 'regular user', 'single user'].each do |persona|
+            context "as #{persona}" do
+                before(:all) do
+                    @session = as(persona)
+                    @filename = "sync-#{SecureRandom.hex}.txt"
+                    @data = "hello\nworld\nsync\n" * 1000
+                    @session.write_file(@filename, @data)
+                end
+
+                it 'sends whole file without signatures' do
+                    id = @session.cmd!('SYNC', @filename, 1024, signatures('', 1024)).value
+                    contents, literal = sync(@session, id, '', 1024)
+                    expect(contents == @data).to be(true)
+                    expect(literal).to eq(@data.length)
+                end
+
+                it 'sends only block references for identical file' do
+                    id = @session.cmd!('SYNC', @filename, 1024, signatures(@data, 1024)).value
+                    contents, literal = sync(@session, id, @data, 1024)
+                    expect(contents == @data).to be(true)
+                    expect(literal).to eq(0)
+                end
+
+                it 'sends changed bytes' do
+                    old = @data.dup
+                    old[5000, 10] = 'x' * 10
+
+                    id = @session.cmd!('SYNC', @filename, 1024, signatures(old, 1024)).value
+                    contents, literal = sync(@session, id, old, 1024)
+                    expect(contents == @data).to be(true)
+                    expect(literal).to be <= 1024
+                end
+
+                it 'returns NOTFOUND when file does not exist' do
+                    resp = @session.cmd('SYNC', "sync-#{SecureRandom.hex}.txt", 1024, signatures('', 1024))
+                    expect(resp).to be_error('NOTFOUND')
+                end
+
+                it 'returns ARG for folders' do
+                    folder = "sync-#{SecureRandom.hex}"
+                    @session.cmd!('MKDIR', folder)
+                    resp = @session.cmd('SYNC', folder, 1024, signatures('', 1024))
+                    expect(resp).to be_error('ARG')
+                end
+
+                it 'returns ARG for invalid block size' do
+                    resp = @session.cmd('SYNC', @filename, 0, signatures('', 1024))
+                    expect(resp).to be_error('ARG')
+                end
+
+                it 'sends signatures for delta uploads' do
+                    resp = @session.cmd!('STREAM', 'W', @filename, 'DELTA', 1024)
+                    expect(resp.elems[1].row_count).to eq(blocks(@data, 1024).length)
+                    @session.cmd!('CLOSE', resp.elems[0].value)
+                end
+
+                it 'sends no signatures for new files' do
+                    resp = @session.cmd!('STREAM', 'W', "sync-#{SecureRandom.hex}.txt", 'DELTA', 1024)
+                    expect(resp.elems[1].row_count).to eq(0)
+                    @session.cmd!('CLOSE', resp.elems[0].value)
+                end
+
+                it 'applies block references in delta uploads' do
+                    filename = "sync-#{SecureRandom.hex}.txt"
+                    @session.write_file(filename, @data)
+                    id = @session.cmd!('STREAM', 'W', filename, 'DELTA', 1024).elems[0].value
+                    expected = 'abc' + blocks(@data, 1024)[1]
+
+                    @session.put_stream(id)
+                    @session.put_blob('abc')
+                    @session.put_stream(id)
+                    @session.put_int(1)
+                    @session.put_stream(id)
+                    @session.put_null
+
+                    contents = nil
+
+                    50.times do
+                        contents = @session.read_file(filename)
+                        break if contents == expected
+                        sleep 0.020
+                    end
+
+                    expect(contents == expected).to be(true)
+                end
+
+                it 'returns ARG for invalid block references' do
+                    filename = "sync-#{SecureRandom.hex}.txt"
+                    @session.write_file(filename, @data)
+                    id = @session.cmd!('STREAM', 'W', filename, 'DELTA', 1024).elems[0].value
+
+                    @session.put_stream(id)
+                    @session.put_int(100000)
+
+                    resp = @session.get_next
+                    expect(resp.id).to eq(id)
+                    expect(resp.payload).to be_error('ARG')
+                end
+            end
+        end
+    end
+end
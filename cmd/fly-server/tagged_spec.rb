@@ -0,0 +1,60 @@
+require 'securerandom'
+
+RSpec.describe 'Tagged commands' do
+    before(:all) do
+        @session = as('admin')
+    end
+
+    it 'tags the response with the request ID' do
+        @session.put_tagged('req1', 'PING')
+
+        resp = @session.get_next
+        expect(resp).to be_a(Wire::Frame)
+        expect(resp.id).to eq('req1')
+        expect(resp.payload).to be_a(Wire::String)
+        expect(resp.payload.value).to eq('PONG')
+    end
+
+    it 'does not hold up untagged commands' do
+        folder = "tagged-#{SecureRandom.hex}"
+        @session.cmd!('MKDIR', folder)
+
+        @session.put_tagged('req2', 'LIST', folder)
+        resp = @session.cmd('PING')
+
+        expect(resp).to be_a(Wire::String)
+        expect(resp.value).to eq('PONG')
+
+        resp = @session.get_frame('req2')
+        expect(resp.payload).to be_a(Wire::Table)
+    end
+
+    it 'runs several commands at once' do
+        5.times do |i|
+            @session.put_tagged("many#{i}", 'PING')
+        end
+
+        responses = 5.times.map { @session.get_next }
+        tags = responses.map { |r| r.id }
+        expect(tags).to contain_exactly('many0', 'many1', 'many2', 'many3', 'many4')
+
+        responses.each do |r|
+            expect(r.payload.value).to eq('PONG')
+        end
+    end
+
+    it 'returns tagged errors' do
+        @session.put_tagged('req3', 'FOOBAR')
+
+        resp = @session.get_frame('req3')
+        expect(resp.payload).to be_error('CMD')
+    end
+
+    it 'returns tagged protocol error when payload is not a command' do
+        @session.put_stream('req4')
+        @session.put_blob('hello')
+
+        resp = @session.get_frame('req4')
+        expect(resp.payload).to be_error('PROTO')
+    end
+end
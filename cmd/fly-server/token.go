@@ -25,7 +25,7 @@ func handleToken(args []wire.Value, s *sessionInfo) wire.Value {
 
 	buf := new(bytes.Buffer)
 	username := wire.NewString(s.username)
-	expiry := wire.NewString(time.Now().Add(5 * time.Minute).UTC().Format(time.RFC3339Nano))
+	expiry := wire.NewTimestamp(time.Now().Add(5 * time.Minute))
 	payload := wire.NewArray([]wire.Value{username, expiry})
 	payload.WriteTo(buf)
 
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+func handleWindow(args []wire.Value, s *sessionInfo) wire.Value {
+	if len(args) != 1 {
+		return wire.NewError("ARG", "Command WINDOW expects exactly one argument")
+	}
+
+	window, ok := args[0].(*wire.Integer)
+
+	if !ok {
+		return wire.NewError("ARG", "Window should be an integer, got %s", args[0].Name())
+	}
+
+	if window.Value < 0 {
+		return wire.NewError("ARG", "Window should not be negative")
+	}
+
+	s.session.SetWindow(int(window.Value))
+
+	return wire.OK
+}
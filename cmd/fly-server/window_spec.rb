@@ -0,0 +1,40 @@
+require 'securerandom'
+
+RSpec.describe 'WINDOW' do
+    before(:all) do
+        @filename = "window-#{SecureRandom.hex}.txt"
+        admin.write_file(@filename, "hello\nworld\n")
+    end
+
+    after(:all) do
+        admin.cmd!('WINDOW', 0)
+    end
+
+    it 'returns OK' do
+        resp = admin.cmd('WINDOW', 0)
+        expect(resp).to be_a(Wire::String)
+        expect(resp.value).to eq('OK')
+    end
+
+    it 'limits data sent by new streams' do
+        admin.cmd!('WINDOW', 8)
+        id = admin.cmd!('STREAM', 'R', @filename).value
+
+        resp = admin.get_frame(id)
+        expect(resp.payload).to be_a(Wire::Blob)
+        expect(resp.payload.value).to eq("hello\nwo")
+
+        admin.cmd!('CLOSE', id)
+    end
+
+    it 'does not limit streams when set to zero' do
+        admin.cmd!('WINDOW', 0)
+        contents = admin.read_file(@filename)
+        expect(contents).to eq("hello\nworld\n")
+    end
+
+    it 'returns ARG for negative window' do
+        resp = admin.cmd('WINDOW', -1)
+        expect(resp).to be_error('ARG')
+    end
+end
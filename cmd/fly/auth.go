@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// Tokens are valid for 5 minutes from the moment the server creates them,
+// they're cached for a bit less so that they don't expire on the way there.
+const tokenLifetime = 5*time.Minute - 10*time.Second
+
+// Implemented by flyclient.Client
+type authenticator interface {
+	Auth(username string, password string) error
+	AuthToken(token string) error
+	Token() (string, error)
+}
+
+// An authentication token returned by TOKEN, cached in ~/.fly/tokens
+type cachedToken struct {
+	host     string
+	username string
+	token    string
+	expiry   time.Time
+}
+
+// Authenticates with a cached token when there is one, otherwise with a
+// password taken from FLY_PASSWORD or prompted for. Without a username, the
+// last token cached for the host is used, and nothing is sent when there is
+// none (for servers in single-user mode).
+func login(a authenticator, host string, username string) error {
+	if t := findToken(host, username); t != nil {
+		if err := a.AuthToken(t.token); err == nil {
+			return nil
+		}
+
+		// The token may have been created before the server restarted
+		username = t.username
+	}
+
+	if username == "" {
+		return nil
+	}
+
+	password := os.Getenv("FLY_PASSWORD")
+
+	if password == "" {
+		var err error
+		password, err = readPassword(fmt.Sprintf("Password for %s: ", username))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return passwordLogin(a, host, username, password)
+}
+
+// Authenticates with a password, then caches a token so that the next
+// commands don't need the password
+func passwordLogin(a authenticator, host string, username string, password string) error {
+	if err := a.Auth(username, password); err != nil {
+		return err
+	}
+
+	token, err := a.Token()
+
+	if err != nil {
+		// Older servers may not support tokens, which only means asking for the password again
+		return nil
+	}
+
+	err = saveToken(cachedToken{
+		host:     host,
+		username: username,
+		token:    token,
+		expiry:   time.Now().Add(tokenLifetime),
+	})
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to cache authentication token: %v\n", err)
+	}
+
+	return nil
+}
+
+// Returns the token for the user, or the last token cached for the host when
+// username is empty. Expired tokens are ignored.
+func findToken(host string, username string) *cachedToken {
+	tokens, err := readTokens()
+
+	if err != nil {
+		return nil
+	}
+
+	var found *cachedToken
+
+	for i, t := range tokens {
+		if t.host == host && (username == "" || t.username == username) && time.Now().Before(t.expiry) {
+			found = &tokens[i]
+		}
+	}
+
+	return found
+}
+
+func readTokens() ([]cachedToken, error) {
+	homeDir, err := os.UserHomeDir()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home: %w", err)
+	}
+
+	f, err := os.Open(path.Join(homeDir, ".fly/tokens"))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return []cachedToken{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open .fly/tokens for reading: %w", err)
+	}
+
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .fly/tokens: %w", err)
+	}
+
+	tokens := make([]cachedToken, 0, len(records))
+
+	for _, record := range records {
+		if len(record) != 4 {
+			continue
+		}
+
+		expiry, err := time.Parse(time.RFC3339, record[3])
+
+		if err != nil {
+			continue
+		}
+
+		tokens = append(tokens, cachedToken{
+			host:     record[0],
+			username: record[1],
+			token:    record[2],
+			expiry:   expiry,
+		})
+	}
+
+	return tokens, nil
+}
+
+// Replaces the token of the same user, dropping the tokens that expired
+func saveToken(token cachedToken) error {
+	tokens, err := readTokens()
+
+	if err != nil {
+		return err
+	}
+
+	homeDir, err := os.UserHomeDir()
+
+	if err != nil {
+		return fmt.Errorf("failed to get user home: %w", err)
+	}
+
+	flyFolder := path.Join(homeDir, ".fly")
+
+	if err := os.MkdirAll(flyFolder, 0700); err != nil {
+		return fmt.Errorf("failed to create .fly: %w", err)
+	}
+
+	records := make([][]string, 0, len(tokens)+1)
+
+	for _, t := range tokens {
+		if time.Now().After(t.expiry) || (t.host == token.host && t.username == token.username) {
+			continue
+		}
+
+		records = append(records, []string{t.host, t.username, t.token, t.expiry.Format(time.RFC3339)})
+	}
+
+	records = append(records, []string{token.host, token.username, token.token, token.expiry.Format(time.RFC3339)})
+
+	// Written to a temporary file first, so that concurrent commands don't see half a file
+	tokenPath := path.Join(flyFolder, "tokens")
+	tmpPath := fmt.Sprintf("%s.%d", tokenPath, os.Getpid())
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+
+	if err != nil {
+		return fmt.Errorf("failed to open .fly/tokens for writing: %w", err)
+	}
+
+	writer := csv.NewWriter(f)
+	writer.WriteAll(records)
+	err = writer.Error()
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmpPath, tokenPath)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write to .fly/tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Settings for a server, defined in ~/.fly/config e.g.
+//
+//	host prod
+//	    address files.example.com
+//	    port 1234
+//	    tls off
+//	    user deploy
+//	    dir /releases
+//	    fingerprint 7b79d79f...
+//
+// Remote paths on an alias (//prod/some/file) are relative to its folder.
+type hostAlias struct {
+	name        string
+	address     string // host:port
+	port        string
+	notls       bool
+	user        string
+	dir         string
+	fingerprint string
+}
+
+// Loaded by main, by alias name
+var aliases = map[string]*hostAlias{}
+
+func readConfig() (map[string]*hostAlias, error) {
+	homeDir, err := os.UserHomeDir()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home: %w", err)
+	}
+
+	f, err := os.Open(path.Join(homeDir, ".fly/config"))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]*hostAlias{}, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open .fly/config: %w", err)
+	}
+
+	defer f.Close()
+
+	return parseConfig(bufio.NewScanner(f))
+}
+
+func parseConfig(scanner *bufio.Scanner) (map[string]*hostAlias, error) {
+	hosts := make(map[string]*hostAlias)
+	var current *hostAlias
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf(".fly/config:%d: expected a key and a value", lineNo)
+		}
+
+		key, value := strings.ToLower(fields[0]), fields[1]
+
+		if key == "host" {
+			current = &hostAlias{name: value, address: value, dir: "/"}
+			hosts[value] = current
+			continue
+		}
+
+		if current == nil {
+			return nil, fmt.Errorf(".fly/config:%d: %s must come after a host line", lineNo, key)
+		}
+
+		switch key {
+		case "address":
+			current.address = value
+		case "port":
+			if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+				return nil, fmt.Errorf(".fly/config:%d: invalid port %s", lineNo, value)
+			}
+
+			current.port = value
+		case "tls":
+			enabled, ok := parseSwitch(value)
+
+			if !ok {
+				return nil, fmt.Errorf(".fly/config:%d: tls should be on or off, got %s", lineNo, value)
+			}
+
+			current.notls = !enabled
+		case "user":
+			current.user = value
+		case "dir":
+			current.dir = path.Clean("/" + value)
+		case "fingerprint":
+			current.fingerprint = strings.ToLower(value)
+		default:
+			return nil, fmt.Errorf(".fly/config:%d: unknown setting %s", lineNo, key)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read .fly/config: %w", err)
+	}
+
+	for _, h := range hosts {
+		if h.port != "" {
+			h.address = net.JoinHostPort(hostname(h.address), h.port)
+		} else if !strings.Contains(h.address, ":") {
+			h.address += ":6767"
+		}
+	}
+
+	return hosts, nil
+}
+
+// Strips the port, if any
+func hostname(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+
+	return address
+}
+
+func parseSwitch(value string) (enabled bool, ok bool) {
+	switch strings.ToLower(value) {
+	case "on", "yes":
+		return true, true
+	case "off", "no":
+		return false, true
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	return enabled, err == nil
+}
+
+// Returns the fingerprint pinned in the config for the host:port, if any
+func pinnedFingerprint(host string) string {
+	for _, alias := range aliases {
+		if alias.address == host && alias.fingerprint != "" {
+			return alias.fingerprint
+		}
+	}
+
+	return ""
+}
+
+// The -notls flag can disable TLS, even when the config enables it
+func (t target) disableTls(flag bool) bool {
+	return flag || (t.alias != nil && t.alias.notls)
+}
+
+// The -user flag takes precedence over the config
+func (t target) username(flag string) string {
+	if flag == "" && t.alias != nil {
+		return t.alias.user
+	}
+
+	return flag
+}
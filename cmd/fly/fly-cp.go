@@ -2,26 +2,23 @@ package main
 
 import (
 	"bufio"
-	"crypto/sha256"
 	"encoding/csv"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"log"
-	"net"
 	"os"
 	"path"
-	"strconv"
 	"strings"
-	"time"
 
-	"github.com/ngagnon/flybywire/internal/wire"
+	"github.com/ngagnon/flybywire/pkg/flyclient"
 )
 
 type target struct {
 	path string
 	host string
+
+	// Set when host is an alias from ~/.fly/config
+	alias *hostAlias
 }
 
 type knownHost struct {
@@ -33,57 +30,65 @@ type fingerprintError struct {
 	fingerprint string
 	err         string
 	changed     bool
-}
-
-type remoteFileInfo struct {
-	isFile bool
+	pinned      bool // the fingerprint comes from .fly/config
 }
 
 func flycp(args []string) {
 	f := flag.NewFlagSet("cp", flag.ContinueOnError)
 	notls := f.Bool("notls", false, "Disable TLS")
+	user := f.String("user", "", "Authenticate as this user")
+	compressed := f.Bool("z", false, "Compress data during transfer")
 
 	err := f.Parse(args)
 
 	if err != nil {
-		printUsage()
-		return
+		os.Exit(exitUsage)
 	}
 
 	args = f.Args()
 
-	if len(args) != 2 {
-		if len(args) > 2 {
-			fmt.Println("Only 2 arguments (with no * wildcard) are supported at this time")
-			fmt.Print()
-		}
+	if len(args) < 2 {
+		commandUsage("cp SOURCE... DEST")
+	}
+
+	sources := make([]target, len(args)-1)
 
-		printUsage()
-		return
+	for i, arg := range args[:len(args)-1] {
+		sources[i] = parseTarget(arg)
+
+		if sources[i].host != sources[0].host {
+			exitWith("All the sources must be on the same server", exitUsage)
+		}
 	}
 
-	source := parseTarget(args[0])
-	dest := parseTarget(args[1])
+	source := sources[0]
+	dest := parseTarget(args[len(args)-1])
 
 	if source.host != "" && dest.host != "" {
-		fmt.Println("Transfers between servers are not currently supported")
-		fmt.Println()
-		return
+		exitWith("Transfers between servers are not currently supported", exitError)
 	}
 
 	if source.host == "" && dest.host == "" {
-		fmt.Println("Local file transfers are not currently supported")
-		fmt.Println()
-		return
+		exitWith("Local file transfers are not currently supported", exitError)
 	}
 
-	host := source.host
+	remote := source
 
 	if dest.host != "" {
-		host = dest.host
+		remote = dest
 	}
 
-	conn, err := connect(host, *notls)
+	opts := flyclient.Options{NoTLS: remote.disableTls(*notls), Compress: *compressed}
+
+	if err := copyMany(sources, dest, opts, remote.username(*user)); err != nil {
+		os.Exit(exitCode(err))
+	}
+}
+
+// Runs connect, asking the user whether to trust the host when its fingerprint
+// is unknown, in which case connect is run again.
+func connectTrusted(host string, connect func() error) bool {
+	err := connect()
 
 	var e *fingerprintError
 
@@ -92,221 +97,36 @@ func flycp(args []string) {
 			fmt.Println("REMOTE HOST IDENTIFICATION HAS CHANGED!!!")
 			fmt.Println("It is possible that someone is doing something nasty!")
 			fmt.Printf("The host fingerprint is %s\n", e.fingerprint)
-			fmt.Println("Add this fingerprint to ~/.fly/known_hosts to get rid of this message.")
-			return
+
+			if e.pinned {
+				fmt.Println("Update the fingerprint in ~/.fly/config to get rid of this message.")
+			} else {
+				fmt.Println("Add this fingerprint to ~/.fly/known_hosts to get rid of this message.")
+			}
+
+			return false
 		}
 
 		if !trustPrompt(host, e.fingerprint) {
-			return
+			return false
 		}
 
 		err = allowFingerprint(host, e.fingerprint)
 
 		if err != nil {
 			fmt.Printf("Failed to add fingerprint to known hosts: %v\n", err)
-			return
+			return false
 		}
 
-		conn, err = connect(host, *notls)
+		err = connect()
 	}
 
 	if err != nil {
 		fmt.Printf("Failed to connect to %s: %v\n", host, err)
-		return
+		return false
 	}
 
-	defer conn.Close()
-
-	reader := wire.NewReader(conn)
-
-	if source.host == "" {
-		upload(conn, reader, source, dest)
-	} else {
-		download(conn, reader, source, dest)
-	}
-}
-
-func download(conn net.Conn, reader *wire.WireReader, source target, dest target) {
-	info, found := statRemoteFile(conn, reader, source.path)
-
-	if !found {
-		log.Fatalln("Remote: No such file or directory")
-	}
-
-	if !info.isFile {
-		log.Fatalln("Only regular file downloads are currently supported.")
-	}
-
-	dstInfo, err := os.Stat(dest.path)
-
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		log.Fatalf("%s: %v\n", dest.path, err)
-	}
-
-	if !errors.Is(err, os.ErrNotExist) && dstInfo.IsDir() {
-		dest.path = path.Join(dest.path, path.Base(source.path))
-	}
-
-	tmpPath := dest.path + ".fly-download"
-	f, err := os.Create(tmpPath)
-
-	if err != nil {
-		log.Fatalf("%s: %v\n", dest.path, err)
-	}
-
-	r := sendCommand(conn, reader, "STREAM", "R", source.path)
-
-	if wireErr, ok := r.(*wire.Error); ok {
-		log.Fatalf("Remote: %s\n", wireErr.Message)
-	}
-
-	streamId := strconv.Itoa(r.(*wire.Integer).Value)
-
-	for {
-		val, err := reader.Read()
-
-		if err != nil {
-			log.Fatalf("Failed to read from socket: %v\n", err)
-		}
-
-		tagged, isTagged := val.(*wire.TaggedValue)
-
-		if !isTagged {
-			log.Fatalf("Unexpected %s, was expected tag\n", val.Name())
-		}
-
-		if tagged.Tag != streamId {
-			log.Fatalf("Unexpected stream ID %s\n", tagged.Tag)
-		}
-
-		if tagged.Value == wire.Null {
-			break
-		}
-
-		blob, isBlob := tagged.Value.(*wire.Blob)
-
-		if !isBlob {
-			log.Fatalf("Unexpected %s, was expected blob\n", tagged.Value.Name())
-		}
-
-		_, err = f.Write(blob.Data)
-
-		if err != nil {
-			log.Fatalf("Failed to write to %s: %v\n", dest.path, err)
-		}
-	}
-
-	f.Close()
-
-	err = os.Rename(tmpPath, dest.path)
-
-	if err != nil {
-		log.Fatalf("Rename failed: %v\n", err)
-	}
-}
-
-func upload(conn net.Conn, reader *wire.WireReader, source target, dest target) {
-	info, err := os.Stat(source.path)
-
-	if err != nil {
-		log.Fatalf("%s: %v\n", source.path, err)
-	}
-
-	if !info.Mode().IsRegular() {
-		log.Fatalln("Only regular file uploads are currently supported.")
-	}
-
-	f, err := os.Open(source.path)
-
-	if err != nil {
-		fmt.Printf("%s: %v\n", source.path, err)
-		return
-	}
-
-	defer f.Close()
-
-	// When copying to a folder, append the source filename to the destination path
-	if info, found := statRemoteFile(conn, reader, dest.path); found && !info.isFile {
-		dest.path = path.Join(dest.path, path.Base(source.path))
-	}
-
-	r := sendCommand(conn, reader, "STREAM", "W", dest.path)
-
-	if wireErr, ok := r.(*wire.Error); ok {
-		log.Fatalf("Remote: %s\n", wireErr.Message)
-	}
-
-	streamId := strconv.Itoa(r.(*wire.Integer).Value)
-	buf := make([]byte, 32*1024)
-
-	for {
-		n, err := f.Read(buf)
-
-		if err == io.EOF {
-			break
-		}
-
-		if err != nil {
-			fmt.Printf("Failed to read from %s: %v\n", source.path, err)
-			return
-		}
-
-		blob := wire.NewBlob(buf[0:n])
-		err = wire.NewTaggedValue(blob, streamId).WriteTo(conn)
-
-		if err != nil {
-			fmt.Printf("Failed to write to socket: %v\n", err)
-			return
-		}
-	}
-
-	err = wire.NewTaggedValue(wire.Null, streamId).WriteTo(conn)
-
-	if err != nil {
-		fmt.Printf("Failed to write to socket: %v\n", err)
-		return
-	}
-
-	for i := 0; i < 10; i++ {
-		r := sendCommand(conn, reader, "LIST", dest.path)
-
-		if _, isErr := r.(*wire.Error); !isErr {
-			return
-		}
-
-		time.Sleep(10 * time.Millisecond)
-	}
-
-	fmt.Println("Unknown error occurred")
-}
-
-func statRemoteFile(conn net.Conn, reader *wire.WireReader, remotePath string) (info remoteFileInfo, found bool) {
-	r := sendCommand(conn, reader, "LIST", remotePath)
-
-	wireErr, isErr := r.(*wire.Error)
-
-	if isErr {
-		if wireErr.Code == "NOTFOUND" {
-			return remoteFileInfo{}, false
-		} else {
-			log.Fatalf("Remote: %s\n", wireErr.Message)
-		}
-	}
-
-	table, isTable := r.(*wire.Table)
-	fileName := path.Base(remotePath)
-
-	info = remoteFileInfo{}
-	info.isFile = fileName != "" &&
-		fileName != "/" &&
-		fileName != "." &&
-		fileName != ".." &&
-		isTable &&
-		table.RowCount == 1 &&
-		table.Row(0)[0].(*wire.String).Value == "F" &&
-		table.Row(0)[1].(*wire.String).Value == fileName
-
-	return info, true
+	return true
 }
 
 func trustPrompt(host string, fingerprint string) bool {
@@ -353,22 +173,44 @@ func parseTarget(s string) target {
 		t.path = s[i+1:]
 	}
 
-	if !strings.Contains(t.host, ":") {
+	if alias, found := aliases[t.host]; found {
+		t.alias = alias
+		t.host = alias.address
+		dir := strings.HasSuffix(t.path, "/")
+		t.path = path.Join("/"+alias.dir, t.path)
+
+		// A trailing slash means the destination is a folder
+		if dir && t.path != "/" {
+			t.path += "/"
+		}
+	} else if !strings.Contains(t.host, ":") {
 		t.host += ":6767"
 	}
 
 	return t
 }
 
-func verifyPeerCertificate(host string, rawCert []byte) error {
+// Fingerprints pinned in ~/.fly/config take precedence over known hosts
+func verifyFingerprint(host string, fingerprint string) error {
+	if pinned := pinnedFingerprint(host); pinned != "" {
+		if pinned == fingerprint {
+			return nil
+		}
+
+		return &fingerprintError{
+			fingerprint: fingerprint,
+			changed:     true,
+			pinned:      true,
+			err:         "TLS fingerprint doesn't match the one pinned in .fly/config",
+		}
+	}
+
 	knownHosts, err := readKnownHosts()
 
 	if err != nil {
 		return fmt.Errorf("failed to read .fly/known_hosts: %w", err)
 	}
 
-	fingerprint := fmt.Sprintf("%x", sha256.Sum256(rawCert))
-
 	for _, knownHost := range knownHosts {
 		if knownHost.host == host {
 			if knownHost.fingerprint == fingerprint {
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ngagnon/flybywire/pkg/flyclient"
+)
+
+// A non-interactive command, which runs a single server command
+type remoteCommand struct {
+	usage string
+
+	// Whether the arguments are remote paths (//HOST/PATH), which must all be
+	// on the same server. Otherwise, the first argument is the server and the
+	// others are passed as is.
+	paths bool
+
+	// Number of arguments, not counting the server. max is -1 when unlimited.
+	min, max int
+
+	run func(c *flyclient.Client, args []string) (result, error)
+}
+
+// Output of a command, printed as JSON with -json
+type result interface {
+	print()
+}
+
+type fileList []*flyclient.FileInfo
+
+type fileStat struct {
+	*flyclient.FileInfo
+}
+
+type fileJSON struct {
+	Name    string     `json:"name"`
+	Type    string     `json:"type"`
+	Size    *int64     `json:"size,omitempty"`
+	ModTime *time.Time `json:"modTime,omitempty"`
+}
+
+type userList []string
+
+type policyList []*flyclient.Policy
+
+type policyJSON struct {
+	Name   string   `json:"name"`
+	Verb   string   `json:"verb"`
+	Action string   `json:"action"`
+	Users  []string `json:"users"`
+	Paths  []string `json:"paths"`
+}
+
+type errorJSON struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var remoteCommands = map[string]remoteCommand{
+	"ls":          {"ls //HOST/PATH", true, 1, 1, runList},
+	"mkdir":       {"mkdir //HOST/PATH...", true, 1, -1, runMkdir},
+	"mv":          {"mv //HOST/SOURCE //HOST/DEST", true, 2, 2, runMove},
+	"rm":          {"rm //HOST/PATH...", true, 1, -1, runDelete},
+	"stat":        {"stat //HOST/PATH", true, 1, 1, runStat},
+	"touch":       {"touch //HOST/PATH...", true, 1, -1, runTouch},
+	"user list":   {"user list //HOST", false, 0, 0, runListUsers},
+	"user add":    {"user add //HOST USERNAME", false, 1, 1, runAddUser},
+	"user rm":     {"user rm //HOST USERNAME", false, 1, 1, runRemoveUser},
+	"user passwd": {"user passwd //HOST USERNAME", false, 1, 1, runSetPassword},
+	"user admin":  {"user admin //HOST USERNAME true|false", false, 2, 2, runSetAdmin},
+	"user chroot": {"user chroot //HOST USERNAME [FOLDER]", false, 1, 2, runChroot},
+	"acp list":    {"acp list //HOST", false, 0, 0, runListPolicies},
+	"acp put":     {"acp put //HOST NAME ALLOW|DENY R|W USER[,USER...] PATH[,PATH...]", false, 5, 5, runPutPolicy},
+	"acp rm":      {"acp rm //HOST NAME", false, 1, 1, runRemovePolicy},
+}
+
+// Runs one of the remoteCommands and exits with one of the exit codes
+func flyremote(name string, args []string) {
+	cmd, found := remoteCommands[name]
+
+	if !found {
+		printUsage()
+		os.Exit(exitUsage)
+	}
+
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	notls := f.Bool("notls", false, "Disable TLS")
+	user := f.String("user", "", "Authenticate as this user")
+	asJSON := f.Bool("json", false, "Print the output as JSON")
+
+	if err := f.Parse(args); err != nil {
+		os.Exit(exitUsage)
+	}
+
+	args = f.Args()
+
+	if len(args) == 0 || !strings.HasPrefix(args[0], "//") {
+		commandUsage(cmd.usage)
+	}
+
+	remote := parseTarget(args[0])
+	host := remote.host
+	params := args[1:]
+
+	if cmd.paths {
+		params = make([]string, len(args))
+
+		for i, arg := range args {
+			t := parseTarget(arg)
+
+			if t.host != host {
+				exitWith("All the paths must be on the same server", exitUsage)
+			}
+
+			params[i] = path.Clean("/" + t.path)
+		}
+	}
+
+	if len(params) < cmd.min || (cmd.max >= 0 && len(params) > cmd.max) {
+		commandUsage(cmd.usage)
+	}
+
+	c, ok := dialClient(host, flyclient.Options{NoTLS: remote.disableTls(*notls)})
+
+	if !ok {
+		os.Exit(exitError)
+	}
+
+	err := login(c, host, remote.username(*user))
+	var res result
+
+	if err == nil {
+		res, err = cmd.run(c, params)
+	}
+
+	c.Close()
+
+	if err != nil {
+		printError(err, *asJSON)
+		os.Exit(exitCode(err))
+	}
+
+	if res == nil {
+		return
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(res)
+	} else {
+		res.print()
+	}
+}
+
+// Errors go to stderr, even in JSON mode
+func printError(err error, asJSON bool) {
+	var flyErr *flyclient.Error
+
+	if !asJSON {
+		if errors.As(err, &flyErr) {
+			fmt.Fprintf(os.Stderr, "Remote: %s\n", flyErr.Message)
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		return
+	}
+
+	e := errorJSON{Code: "ERR", Message: err.Error()}
+
+	if errors.As(err, &flyErr) {
+		e = errorJSON{Code: flyErr.Code, Message: flyErr.Message}
+	}
+
+	json.NewEncoder(os.Stderr).Encode(map[string]errorJSON{"error": e})
+}
+
+// Prints the error and exits with the matching exit code
+func fatal(err error) {
+	printError(err, false)
+	os.Exit(exitCode(err))
+}
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return exitNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return exitDenied
+	default:
+		return exitError
+	}
+}
+
+func runList(c *flyclient.Client, args []string) (result, error) {
+	files, err := c.List(args[0])
+	return fileList(files), err
+}
+
+func runMkdir(c *flyclient.Client, args []string) (result, error) {
+	for _, arg := range args {
+		if err := c.Mkdir(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func runMove(c *flyclient.Client, args []string) (result, error) {
+	return nil, c.Move(args[0], args[1])
+}
+
+func runDelete(c *flyclient.Client, args []string) (result, error) {
+	for _, arg := range args {
+		if err := c.Delete(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// Stat doesn't know the modification time of folders, the parent folder has it
+func runStat(c *flyclient.Client, args []string) (result, error) {
+	info, err := c.Stat(args[0])
+
+	if err != nil || !info.IsDir() || info.Name() == "/" {
+		return fileStat{info}, err
+	}
+
+	siblings, err := c.List(path.Dir(path.Clean("/" + args[0])))
+
+	if err != nil {
+		return fileStat{info}, nil
+	}
+
+	for _, f := range siblings {
+		if f.IsDir() && f.Name() == info.Name() {
+			return fileStat{f}, nil
+		}
+	}
+
+	return fileStat{info}, nil
+}
+
+func runTouch(c *flyclient.Client, args []string) (result, error) {
+	for _, arg := range args {
+		if err := c.Touch(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func runListUsers(c *flyclient.Client, args []string) (result, error) {
+	users, err := c.ListUsers()
+
+	if users == nil {
+		users = []string{}
+	}
+
+	return userList(users), err
+}
+
+func runAddUser(c *flyclient.Client, args []string) (result, error) {
+	password, err := readNewPassword()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, c.AddUser(args[0], password)
+}
+
+func runRemoveUser(c *flyclient.Client, args []string) (result, error) {
+	return nil, c.RemoveUser(args[0])
+}
+
+func runSetPassword(c *flyclient.Client, args []string) (result, error) {
+	password, err := readNewPassword()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, c.SetPassword(args[0], password)
+}
+
+func runSetAdmin(c *flyclient.Client, args []string) (result, error) {
+	admin, err := strconv.ParseBool(args[1])
+
+	if err != nil {
+		return nil, fmt.Errorf("expected true or false, got %s", args[1])
+	}
+
+	return nil, c.SetAdmin(args[0], admin)
+}
+
+// Without a folder, the chroot is removed
+func runChroot(c *flyclient.Client, args []string) (result, error) {
+	folder := ""
+
+	if len(args) == 2 {
+		folder = args[1]
+	}
+
+	return nil, c.Chroot(args[0], folder)
+}
+
+func runListPolicies(c *flyclient.Client, args []string) (result, error) {
+	policies, err := c.ListPolicies()
+	return policyList(policies), err
+}
+
+func runPutPolicy(c *flyclient.Client, args []string) (result, error) {
+	return nil, c.PutPolicy(&flyclient.Policy{
+		Name:   args[0],
+		Verb:   strings.ToUpper(args[1]),
+		Action: strings.ToUpper(args[2]),
+		Users:  strings.Split(args[3], ","),
+		Paths:  strings.Split(args[4], ","),
+	})
+}
+
+func runRemovePolicy(c *flyclient.Client, args []string) (result, error) {
+	return nil, c.RemovePolicy(args[0])
+}
+
+// Sorted by name, as LIST doesn't always sort the files
+func printFiles(files []*flyclient.FileInfo) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	for _, f := range files {
+		size := fmt.Sprint(f.Size())
+		name := f.Name()
+
+		if f.IsDir() {
+			size = "-"
+			name += "/"
+		}
+
+		fmt.Printf("%12s  %s  %s\n", size, f.ModTime().Local().Format("2006-01-02 15:04"), name)
+	}
+}
+
+// The modification time of the root folder isn't known, it's left out
+func newFileJSON(f *flyclient.FileInfo) fileJSON {
+	j := fileJSON{Name: f.Name(), Type: "folder"}
+
+	if !f.IsDir() {
+		size := f.Size()
+		j.Type = "file"
+		j.Size = &size
+	}
+
+	if mtime := f.ModTime(); !mtime.IsZero() {
+		j.ModTime = &mtime
+	}
+
+	return j
+}
+
+func (l fileList) print() {
+	printFiles(l)
+}
+
+func (l fileList) MarshalJSON() ([]byte, error) {
+	files := make([]fileJSON, len(l))
+
+	for i, f := range l {
+		files[i] = newFileJSON(f)
+	}
+
+	return json.Marshal(files)
+}
+
+func (s fileStat) print() {
+	f := newFileJSON(s.FileInfo)
+
+	fmt.Printf("Name: %s\n", f.Name)
+	fmt.Printf("Type: %s\n", f.Type)
+
+	if f.Size != nil {
+		fmt.Printf("Size: %d\n", *f.Size)
+	}
+
+	if f.ModTime != nil {
+		fmt.Printf("Modified: %s\n", f.ModTime.Local().Format(time.RFC3339))
+	}
+}
+
+func (s fileStat) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newFileJSON(s.FileInfo))
+}
+
+func (l userList) print() {
+	for _, username := range l {
+		fmt.Println(username)
+	}
+}
+
+func (l policyList) print() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERB\tACTION\tUSERS\tPATHS")
+
+	for _, p := range l {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Name, p.Verb, p.Action, strings.Join(p.Users, ","), strings.Join(p.Paths, ","))
+	}
+
+	w.Flush()
+}
+
+func (l policyList) MarshalJSON() ([]byte, error) {
+	policies := make([]policyJSON, len(l))
+
+	for i, p := range l {
+		policies[i] = policyJSON(*p)
+	}
+
+	return json.Marshal(policies)
+}
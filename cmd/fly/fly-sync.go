@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ngagnon/flybywire/pkg/flyclient"
+)
+
+type syncEntry struct {
+	isDir bool
+	size  int64
+	mtime time.Time
+}
+
+func flysync(args []string) {
+	f := flag.NewFlagSet("sync", flag.ContinueOnError)
+	notls := f.Bool("notls", false, "Disable TLS")
+	user := f.String("user", "", "Authenticate as this user")
+	del := f.Bool("delete", false, "Delete extra files from DEST")
+	compressed := f.Bool("z", false, "Compress data during transfer")
+
+	err := f.Parse(args)
+
+	if err != nil {
+		os.Exit(exitUsage)
+	}
+
+	args = f.Args()
+
+	if len(args) != 2 {
+		commandUsage("sync [-delete] SOURCE DEST")
+	}
+
+	source := parseTarget(args[0])
+	dest := parseTarget(args[1])
+
+	if source.host != "" && dest.host != "" {
+		exitWith("Transfers between servers are not currently supported", exitError)
+	}
+
+	if source.host == "" && dest.host == "" {
+		exitWith("Local file transfers are not currently supported", exitError)
+	}
+
+	remote := source
+
+	if dest.host != "" {
+		remote = dest
+	}
+
+	host := remote.host
+	c, ok := dialClient(host, flyclient.Options{NoTLS: remote.disableTls(*notls), Compress: *compressed})
+
+	if !ok {
+		os.Exit(exitError)
+	}
+
+	err = login(c, host, remote.username(*user))
+
+	if err == nil {
+		err = syncFiles(c, source, dest, *del)
+	}
+
+	c.Close()
+
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// Mirrors source into dest, one of them is on the server c is connected to
+func syncFiles(c *flyclient.Client, source target, dest target, del bool) error {
+	srcFiles, err := walk(c, source)
+
+	if err != nil {
+		return err
+	}
+
+	dstFiles, err := walk(c, dest)
+
+	if err != nil {
+		return err
+	}
+
+	if len(srcFiles) == 0 {
+		return fmt.Errorf("%s: %w", source.path, fs.ErrNotExist)
+	}
+
+	// When syncing a single file into a folder, append the source filename to the destination path
+	if root, ok := srcFiles[""]; ok && !root.isDir {
+		if dstRoot, ok := dstFiles[""]; ok && dstRoot.isDir {
+			dest.path = path.Join(dest.path, path.Base(source.path))
+
+			if dstFiles, err = walk(c, dest); err != nil {
+				return err
+			}
+		}
+	}
+
+	removed := make(map[string]bool)
+
+	for _, name := range sortedNames(srcFiles) {
+		src := srcFiles[name]
+		dst, exists := dstFiles[name]
+		srcPath := joinPath(source.path, name)
+		dstPath := joinPath(dest.path, name)
+
+		if exists && dst.isDir != src.isDir {
+			fmt.Printf("delete %s\n", displayName(name))
+
+			if err := deleteFile(c, dest, dstPath); err != nil {
+				return err
+			}
+
+			removed[name] = true
+			exists = false
+		}
+
+		if src.isDir {
+			if !exists {
+				if err := makeFolder(c, dest, dstPath); err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		if exists && dst.size == src.size && !src.mtime.After(dst.mtime) {
+			continue
+		}
+
+		if source.host == "" {
+			fmt.Printf("upload %s\n", displayName(name))
+
+			if err := syncUpload(c, srcPath, dstPath); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("download %s\n", displayName(name))
+
+			if err := syncDownload(c, srcPath, dstPath); err != nil {
+				return err
+			}
+
+			if err := os.Chtimes(dstPath, src.mtime, src.mtime); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !del {
+		return nil
+	}
+
+	for _, name := range sortedNames(dstFiles) {
+		if _, ok := srcFiles[name]; ok || parentRemoved(name, removed) {
+			continue
+		}
+
+		fmt.Printf("delete %s\n", displayName(name))
+
+		if err := deleteFile(c, dest, joinPath(dest.path, name)); err != nil {
+			return err
+		}
+
+		removed[name] = true
+	}
+
+	return nil
+}
+
+func walk(c *flyclient.Client, t target) (map[string]syncEntry, error) {
+	if t.host == "" {
+		return walkLocal(t.path)
+	}
+
+	return walkRemote(c, t.path)
+}
+
+// Whether the file was already removed along with one of its parent folders
+func parentRemoved(name string, removed map[string]bool) bool {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if removed[dir] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func walkLocal(root string) (map[string]syncEntry, error) {
+	info, err := os.Stat(root)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]syncEntry{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return map[string]syncEntry{"": newSyncEntry(info)}, nil
+	}
+
+	files := make(map[string]syncEntry)
+
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		// Leftovers from interrupted downloads
+		if strings.HasSuffix(p, ".fly-download") {
+			return nil
+		}
+
+		name, err := filepath.Rel(root, p)
+
+		if err != nil {
+			return err
+		}
+
+		if name == "." {
+			name = ""
+		}
+
+		files[filepath.ToSlash(name)] = newSyncEntry(info)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func newSyncEntry(info os.FileInfo) syncEntry {
+	return syncEntry{
+		isDir: info.IsDir(),
+		size:  info.Size(),
+		mtime: info.ModTime(),
+	}
+}
+
+func walkRemote(c *flyclient.Client, root string) (map[string]syncEntry, error) {
+	info, err := c.Stat(root)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]syncEntry{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return map[string]syncEntry{"": newSyncEntry(info)}, nil
+	}
+
+	files := map[string]syncEntry{"": {isDir: true}}
+
+	if err := walkRemoteFolder(c, root, "", files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func walkRemoteFolder(c *flyclient.Client, root string, dir string, files map[string]syncEntry) error {
+	list, err := c.List(joinPath(root, dir))
+
+	if err != nil {
+		return err
+	}
+
+	for _, info := range list {
+		name := path.Join(dir, info.Name())
+		files[name] = newSyncEntry(info)
+
+		if info.IsDir() {
+			if err := walkRemoteFolder(c, root, name, files); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Downloads a remote file, only transferring the parts that differ from the local copy (if any)
+func syncDownload(c *flyclient.Client, remotePath string, localPath string) error {
+	base, err := os.Open(localPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return downloadFile(c, remotePath, localPath)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer base.Close()
+
+	info, err := base.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	tmpPath := localPath + ".fly-download"
+	f, err := os.Create(tmpPath)
+
+	if err != nil {
+		return err
+	}
+
+	err = c.Sync(remotePath, base, info.Size(), f)
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, localPath)
+}
+
+// Uploads a local file, only transferring the parts that differ from the remote copy (if any)
+func syncUpload(c *flyclient.Client, localPath string, remotePath string) error {
+	f, err := os.Open(localPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	return c.Patch(remotePath, f, info.Size())
+}
+
+func makeFolder(c *flyclient.Client, t target, p string) error {
+	if t.host == "" {
+		return os.MkdirAll(p, 0755)
+	}
+
+	return c.Mkdir(p)
+}
+
+func deleteFile(c *flyclient.Client, t target, p string) error {
+	if t.host == "" {
+		return os.RemoveAll(p)
+	}
+
+	return c.Delete(p)
+}
+
+func sortedNames(files map[string]syncEntry) []string {
+	names := make([]string, 0, len(files))
+
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func joinPath(root string, name string) string {
+	if name == "" {
+		return root
+	}
+
+	return path.Join(root, name)
+}
+
+func displayName(name string) string {
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ngagnon/flybywire/pkg/flyclient"
+	"golang.org/x/term"
+)
+
+// An interactive session with a server
+type shell struct {
+	client *flyclient.Client
+	host   string
+	cwd    string
+
+	// Nil when stdin isn't a terminal, lines are then read from scanner
+	term    *term.Terminal
+	scanner *bufio.Scanner
+}
+
+type shellCommand struct {
+	usage string
+
+	// Whether the arguments are paths, which are completed with tab
+	paths bool
+
+	run func(s *shell, args []string) error
+}
+
+// A word of a command line, start and end being its offsets in the line
+type shellWord struct {
+	value string
+	start int
+	end   int
+}
+
+var shellCommands = map[string]shellCommand{
+	"auth":   {"auth USERNAME", false, (*shell).auth},
+	"cd":     {"cd [PATH]", true, (*shell).cd},
+	"cp":     {"cp SOURCE DEST", true, (*shell).cp},
+	"exit":   {"exit", false, nil},
+	"help":   {"help", false, nil},
+	"ls":     {"ls [PATH]", true, (*shell).ls},
+	"mkdir":  {"mkdir PATH...", true, (*shell).mkdir},
+	"mv":     {"mv SOURCE DEST", true, (*shell).mv},
+	"pwd":    {"pwd", false, (*shell).pwd},
+	"rm":     {"rm PATH...", true, (*shell).rm},
+	"whoami": {"whoami", false, (*shell).whoami},
+}
+
+var errUsage = errors.New("invalid arguments")
+
+func flyto(args []string) {
+	f := flag.NewFlagSet("to", flag.ContinueOnError)
+	notls := f.Bool("notls", false, "Disable TLS")
+	user := f.String("user", "", "Authenticate as this user")
+
+	err := f.Parse(args)
+
+	if err != nil {
+		os.Exit(exitUsage)
+	}
+
+	if f.NArg() != 1 {
+		commandUsage("to HOST[:PORT][/PATH]")
+	}
+
+	t := parseTarget("//" + strings.TrimPrefix(f.Arg(0), "//"))
+	s := &shell{host: t.host, cwd: path.Clean("/" + t.path)}
+	client, ok := dialClient(t.host, flyclient.Options{NoTLS: t.disableTls(*notls)})
+
+	if !ok {
+		os.Exit(exitError)
+	}
+
+	s.client = client
+
+	if err := login(client, t.host, t.username(*user)); err != nil {
+		fatal(err)
+	}
+
+	defer s.client.Close()
+
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		s.term = term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, "")
+		s.term.AutoCompleteCallback = s.complete
+	} else {
+		s.scanner = bufio.NewScanner(os.Stdin)
+	}
+
+	s.run()
+}
+
+func (s *shell) run() {
+	for {
+		line, err := s.readLine(fmt.Sprintf("%s:%s> ", s.host, s.cwd))
+
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("Failed to read user input: %v\n", err)
+			}
+
+			return
+		}
+
+		words, err := splitWords(line)
+
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		if len(words) == 0 {
+			continue
+		}
+
+		name := words[0].value
+		args := make([]string, len(words)-1)
+
+		for i, w := range words[1:] {
+			args[i] = w.value
+		}
+
+		cmd, found := shellCommands[name]
+
+		switch {
+		case !found:
+			fmt.Printf("%s: unknown command, type help for a list of commands\n", name)
+		case name == "exit":
+			return
+		case name == "help":
+			s.help()
+		default:
+			err = cmd.run(s, args)
+		}
+
+		if err == errUsage {
+			fmt.Printf("Usage: %s\n", cmd.usage)
+		} else if err != nil {
+			fmt.Printf("%s: %s\n", name, errorMessage(err))
+
+			// Errors that don't come from the server may mean that the connection was lost
+			var flyErr *flyclient.Error
+
+			if !errors.As(err, &flyErr) && s.client.Ping() != nil {
+				fmt.Println("Connection lost")
+				return
+			}
+		}
+	}
+}
+
+// The terminal is only in raw mode while a line is being edited, so that
+// Ctrl-C still interrupts a transfer.
+func (s *shell) readLine(prompt string) (string, error) {
+	if s.term == nil {
+		if !s.scanner.Scan() {
+			if err := s.scanner.Err(); err != nil {
+				return "", err
+			}
+
+			return "", io.EOF
+		}
+
+		return s.scanner.Text(), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer term.Restore(fd, state)
+
+	if width, height, err := term.GetSize(fd); err == nil && width > 0 {
+		s.term.SetSize(width, height)
+	}
+
+	s.term.SetPrompt(prompt)
+	return s.term.ReadLine()
+}
+
+func (s *shell) readPassword(prompt string) (string, error) {
+	if s.term == nil {
+		return s.readLine(prompt)
+	}
+
+	return readPassword(prompt)
+}
+
+func (s *shell) help() {
+	names := make([]string, 0, len(shellCommands))
+
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Printf("  %s\n", shellCommands[name].usage)
+	}
+
+	fmt.Println()
+	fmt.Println("Paths are relative to the working directory.")
+	fmt.Println("cp transfers files between the local machine and the server, remote paths start with ':' e.g. 'cp :report.pdf .'")
+}
+
+// Makes a remote path absolute, relative paths being relative to the working directory
+func (s *shell) resolve(p string) string {
+	if strings.HasPrefix(p, "/") {
+		return path.Clean(p)
+	}
+
+	return path.Join(s.cwd, p)
+}
+
+func (s *shell) auth(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	password, err := s.readPassword("Password: ")
+
+	if err != nil {
+		return err
+	}
+
+	return passwordLogin(s.client, s.host, args[0], password)
+}
+
+func (s *shell) cd(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+
+	dir := "/"
+
+	if len(args) == 1 {
+		dir = s.resolve(args[0])
+	}
+
+	info, err := s.client.Stat(dir)
+
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s: not a folder", dir)
+	}
+
+	s.cwd = dir
+	return nil
+}
+
+// Paths that start with ':' are remote, the others are local
+func (s *shell) cp(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	source, dest := args[0], args[1]
+	remoteSource := strings.HasPrefix(source, ":")
+	remoteDest := strings.HasPrefix(dest, ":")
+
+	switch {
+	case remoteSource && remoteDest:
+		source, dest = s.resolve(source[1:]), s.resolve(dest[1:])
+
+		if info, err := s.client.Stat(dest); err == nil && info.IsDir() {
+			dest = path.Join(dest, path.Base(source))
+		}
+
+		return s.client.Copy(source, dest)
+	case remoteSource:
+		return getFile(s.client, s.resolve(source[1:]), dest)
+	case remoteDest:
+		return putFile(s.client, source, s.resolve(dest[1:]))
+	default:
+		return errors.New("local file transfers are not supported, prefix remote paths with ':'")
+	}
+}
+
+func (s *shell) ls(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+
+	dir := s.cwd
+
+	if len(args) == 1 {
+		dir = s.resolve(args[0])
+	}
+
+	files, err := s.client.List(dir)
+
+	if err != nil {
+		return err
+	}
+
+	printFiles(files)
+	return nil
+}
+
+func (s *shell) mkdir(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	for _, arg := range args {
+		if err := s.client.Mkdir(s.resolve(arg)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *shell) mv(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	return s.client.Move(s.resolve(args[0]), s.resolve(args[1]))
+}
+
+func (s *shell) pwd(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	fmt.Println(s.cwd)
+	return nil
+}
+
+func (s *shell) rm(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	for _, arg := range args {
+		if err := s.client.Delete(s.resolve(arg)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *shell) whoami(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	username, err := s.client.WhoAmI()
+
+	if err != nil {
+		return err
+	}
+
+	if username == "" {
+		fmt.Println("Not authenticated")
+	} else {
+		fmt.Println(username)
+	}
+
+	return nil
+}
+
+// Called by the terminal on every key press, completes the word under the
+// cursor when tab is pressed. When there are several possible completions and
+// none of them is longer than the word, they are printed.
+func (s *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	words, _ := splitWords(line[:pos])
+
+	if len(words) == 0 || words[len(words)-1].end < pos {
+		words = append(words, shellWord{start: pos, end: pos})
+	}
+
+	word := words[len(words)-1]
+	prefix := ""
+	var candidates []string
+
+	switch {
+	case len(words) == 1:
+		for name := range shellCommands {
+			candidates = append(candidates, name+" ")
+		}
+	case !shellCommands[words[0].value].paths:
+		return "", 0, false
+	case words[0].value == "cp" && !strings.HasPrefix(word.value, ":"):
+		candidates = localCandidates(word.value)
+	case words[0].value == "cp":
+		prefix = ":"
+		word.value = word.value[1:]
+		fallthrough
+	default:
+		candidates = s.remoteCandidates(word.value)
+	}
+
+	var matches []string
+
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word.value) {
+			matches = append(matches, c)
+		}
+	}
+
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	completion := commonPrefix(matches)
+
+	if len(matches) > 1 && len(completion) == len(word.value) {
+		for i, m := range matches {
+			matches[i] = strings.TrimSuffix(m, " ")
+		}
+
+		sort.Strings(matches)
+		fmt.Fprintf(s.term, "%s\n", strings.Join(matches, "  "))
+		return "", 0, false
+	}
+
+	completion = prefix + quoteWord(strings.TrimSuffix(completion, " "))
+
+	if strings.HasSuffix(matches[0], " ") && len(matches) == 1 {
+		completion += " "
+	}
+
+	return line[:word.start] + completion + line[pos:], word.start + len(completion), true
+}
+
+// Folder names end with a slash, file names with a space
+func (s *shell) remoteCandidates(word string) []string {
+	dir := word[:strings.LastIndex(word, "/")+1]
+	files, err := s.client.List(s.resolve(dir))
+
+	if err != nil {
+		return nil
+	}
+
+	candidates := make([]string, len(files))
+
+	for i, f := range files {
+		candidates[i] = dir + f.Name() + " "
+
+		if f.IsDir() {
+			candidates[i] = dir + f.Name() + "/"
+		}
+	}
+
+	return candidates
+}
+
+func localCandidates(word string) []string {
+	dir := word[:strings.LastIndex(word, "/")+1]
+	entries, err := os.ReadDir(path.Join(".", dir))
+
+	if err != nil {
+		return nil
+	}
+
+	candidates := make([]string, len(entries))
+
+	for i, e := range entries {
+		candidates[i] = dir + e.Name() + " "
+
+		if e.IsDir() {
+			candidates[i] = dir + e.Name() + "/"
+		}
+	}
+
+	return candidates
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	return prefix
+}
+
+// Escapes the characters that splitWords gives a meaning to
+func quoteWord(w string) string {
+	var b strings.Builder
+
+	for _, r := range w {
+		if strings.ContainsRune(" \t\\'\"", r) {
+			b.WriteRune('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// Splits a command line into words, separated by spaces. Quotes and
+// backslashes can be used to include spaces in a word. When a quote isn't
+// closed, the words are returned along with an error.
+func splitWords(line string) ([]shellWord, error) {
+	var words []shellWord
+	var current *shellWord
+	var quote rune
+	escaped := false
+
+	for i, r := range line {
+		if quote == 0 && !escaped && (r == ' ' || r == '\t') {
+			if current != nil {
+				words = append(words, *current)
+				current = nil
+			}
+
+			continue
+		}
+
+		if current == nil {
+			current = &shellWord{start: i}
+		}
+
+		current.end = i + utf8.RuneLen(r)
+
+		switch {
+		case escaped:
+			current.value += string(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote = r
+		default:
+			current.value += string(r)
+		}
+	}
+
+	if current != nil {
+		words = append(words, *current)
+	}
+
+	if quote != 0 {
+		return words, errors.New("unterminated quote")
+	}
+
+	return words, nil
+}
+
+// Strips the error code from server errors
+func errorMessage(err error) string {
+	var flyErr *flyclient.Error
+
+	if errors.As(err, &flyErr) {
+		return flyErr.Message
+	}
+
+	return err.Error()
+}
@@ -5,7 +5,23 @@ import (
 	"os"
 )
 
+// Exit codes of the non-interactive commands
+const (
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+	exitDenied   = 4
+)
+
 func main() {
+	var err error
+	aliases, err = readConfig()
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(exitError)
+	}
+
 	if len(os.Args) < 2 {
 		printUsage()
 		return
@@ -14,17 +30,73 @@ func main() {
 	switch os.Args[1] {
 	case "cp":
 		flycp(os.Args[2:])
+	case "sync":
+		flysync(os.Args[2:])
+	case "to":
+		flyto(os.Args[2:])
+	case "ls", "mkdir", "mv", "rm", "stat", "touch":
+		flyremote(os.Args[1], os.Args[2:])
+	case "user", "acp":
+		if len(os.Args) < 3 {
+			printUsage()
+			os.Exit(exitUsage)
+		}
+
+		flyremote(os.Args[1]+" "+os.Args[2], os.Args[3:])
 	default:
 		printUsage()
+		os.Exit(exitUsage)
 	}
 }
 
+// Prints the usage of a single command, then exits
+func commandUsage(usage string) {
+	fmt.Fprintf(os.Stderr, "Usage: fly %s\n", usage)
+	os.Exit(exitUsage)
+}
+
+// Prints the message, then exits with the given code
+func exitWith(msg string, code int) {
+	fmt.Fprintln(os.Stderr, msg)
+	os.Exit(code)
+}
+
 func printUsage() {
-	fmt.Println("Usage: fly cp SOURCE DEST")
+	fmt.Println("Usage: fly cp SOURCE... DEST")
+	fmt.Println("       fly sync [-delete] SOURCE DEST")
+	fmt.Println("       fly to HOST[:PORT][/PATH]")
+	fmt.Println("       fly ls|stat //HOST/PATH")
+	fmt.Println("       fly rm|mkdir|touch //HOST/PATH...")
+	fmt.Println("       fly mv //HOST/SOURCE //HOST/DEST")
+	fmt.Println("       fly user list //HOST")
+	fmt.Println("       fly user add|rm|passwd //HOST USERNAME")
+	fmt.Println("       fly user admin //HOST USERNAME true|false")
+	fmt.Println("       fly user chroot //HOST USERNAME [FOLDER]")
+	fmt.Println("       fly acp list //HOST")
+	fmt.Println("       fly acp put //HOST NAME ALLOW|DENY R|W USER[,USER...] PATH[,PATH...]")
+	fmt.Println("       fly acp rm //HOST NAME")
 	fmt.Println()
 
 	fmt.Println("Pass -notls flag to disable TLS")
+	fmt.Println("Pass -user flag to authenticate, the password is read from FLY_PASSWORD or prompted for")
+	fmt.Println("Authentication tokens are cached in ~/.fly/tokens, commands run within 5 minutes don't need the password")
+	fmt.Println()
+
+	fmt.Println("cp copies several files, or the files matching * wildcards (in the file name for remote paths), into the DEST folder")
+	fmt.Println()
+
+	fmt.Println("sync mirrors the SOURCE folder into DEST, only transferring files that changed (size or modification time)")
+	fmt.Println("Pass -delete flag to remove files from DEST that are not in SOURCE")
+	fmt.Println()
+
+	fmt.Println("to opens an interactive shell on HOST, starting in PATH (type help for a list of commands)")
+	fmt.Println()
+
+	fmt.Println("The other commands run a single server command, pass -json flag to print the output as JSON")
+	fmt.Println("user add and user passwd prompt for the password, or read it from stdin when it isn't a terminal")
+	fmt.Println("Exit codes: 0 on success, 1 on error, 2 on invalid usage, 3 when a file or user is not found, 4 when access is denied")
 	fmt.Println()
 
 	fmt.Println("A path that starts with '//' denotes a remote path e.g. '//host:port/some/path/file.txt'")
+	fmt.Println("The host can be an alias defined in ~/.fly/config e.g. '//prod/some/path/file.txt'")
 }
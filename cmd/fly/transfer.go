@@ -0,0 +1,349 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ngagnon/flybywire/pkg/flyclient"
+)
+
+// Number of files copied at the same time by copyMany, each over its own stream
+const maxTransfers = 8
+
+// Copies one or several files, or the files matching wildcards, to dest,
+// which must be a folder when there are several files. The transfers run
+// concurrently over a single connection. Errors are printed as they happen,
+// the first one is returned.
+func copyMany(sources []target, dest target, opts flyclient.Options, username string) error {
+	upload := sources[0].host == ""
+	remote := dest
+
+	if !upload {
+		remote = sources[0]
+	}
+
+	c, ok := dialClient(remote.host, opts)
+
+	if !ok {
+		return errors.New("could not connect")
+	}
+
+	defer c.Close()
+
+	if err := login(c, remote.host, username); err != nil {
+		printError(err, false)
+		return err
+	}
+
+	var files []string
+	var err error
+
+	if upload {
+		files, err = expandLocal(sources)
+	} else {
+		files, err = expandRemote(c, sources)
+	}
+
+	if err == nil && len(files) > 1 {
+		err = checkDestFolder(c, dest, upload, files)
+	}
+
+	if err != nil {
+		printError(err, false)
+		return err
+	}
+
+	queue := make(chan string)
+	var firstErr error
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < maxTransfers && i < len(files); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for file := range queue {
+				var err error
+
+				if upload {
+					err = putFile(c, file, dest.path)
+				} else {
+					err = getFile(c, file, dest.path)
+				}
+
+				if err != nil {
+					mutex.Lock()
+
+					if firstErr == nil {
+						firstErr = err
+					}
+
+					fmt.Fprintf(os.Stderr, "%s: %s\n", file, errorMessage(err))
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, file := range files {
+		queue <- file
+	}
+
+	close(queue)
+	wg.Wait()
+
+	return firstErr
+}
+
+// Files whose name contains one of these characters e.g. foo[1].txt are
+// still copied when they exist, see expandLocal and expandRemote
+func hasGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// Folders matching a wildcard are skipped, only files are copied
+func expandLocal(sources []target) ([]string, error) {
+	files := make([]string, 0, len(sources))
+
+	for _, s := range sources {
+		if !hasGlob(s.path) {
+			files = append(files, s.path)
+			continue
+		}
+
+		if _, err := os.Stat(s.path); err == nil {
+			files = append(files, s.path)
+			continue
+		}
+
+		matches, err := filepath.Glob(s.path)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.path, err)
+		}
+
+		found := false
+
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				files = append(files, match)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%s: no matching files", s.path)
+		}
+	}
+
+	return files, nil
+}
+
+// Wildcards are expanded by listing the folder on the server, so they're only
+// supported in the file name e.g. //host/logs/*.gz
+func expandRemote(c *flyclient.Client, sources []target) ([]string, error) {
+	files := make([]string, 0, len(sources))
+
+	for _, s := range sources {
+		p := path.Clean("/" + s.path)
+
+		if !hasGlob(p) {
+			files = append(files, p)
+			continue
+		}
+
+		if _, err := c.Stat(p); err == nil {
+			files = append(files, p)
+			continue
+		}
+
+		folder, pattern := path.Split(p)
+
+		if hasGlob(folder) {
+			return nil, fmt.Errorf("%s: wildcards are only supported in the file name", p)
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+
+		list, err := c.List(folder)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// LIST doesn't always sort the files
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Name() < list[j].Name()
+		})
+
+		found := false
+
+		for _, f := range list {
+			if matched, _ := path.Match(pattern, f.Name()); matched && !f.IsDir() {
+				files = append(files, path.Join(folder, f.Name()))
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%s: no matching files", p)
+		}
+	}
+
+	return files, nil
+}
+
+// Several files can only be copied into an existing folder, and they must not
+// overwrite one another
+func checkDestFolder(c *flyclient.Client, dest target, upload bool, files []string) error {
+	var isDir bool
+
+	if upload {
+		info, err := c.Stat(dest.path)
+		isDir = err == nil && info.IsDir()
+	} else {
+		info, err := os.Stat(dest.path)
+		isDir = err == nil && info.IsDir()
+	}
+
+	if !isDir {
+		return fmt.Errorf("%s: not a folder", dest.path)
+	}
+
+	names := make(map[string]string, len(files))
+
+	for _, file := range files {
+		name := path.Base(file)
+
+		if other, found := names[name]; found {
+			return fmt.Errorf("%s and %s would both be copied to %s", other, file, path.Join(dest.path, name))
+		}
+
+		names[name] = file
+	}
+
+	return nil
+}
+
+// Downloads a remote file to a temporary file, which replaces localPath once
+// the whole file was received. When localPath is a folder, the file is
+// downloaded inside of it.
+func getFile(c *flyclient.Client, remotePath string, localPath string) error {
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		localPath = path.Join(localPath, path.Base(remotePath))
+	}
+
+	return downloadFile(c, remotePath, localPath)
+}
+
+// Picks up an interrupted download where it left off, unless the remote file
+// was modified since
+func downloadFile(c *flyclient.Client, remotePath string, localPath string) error {
+	tmpPath := localPath + ".fly-download"
+	offset := int64(0)
+
+	if _, err := os.Stat(tmpPath); err == nil {
+		info, err := c.Stat(remotePath)
+
+		if err != nil {
+			return err
+		}
+
+		offset = resumeOffset(tmpPath, info)
+	}
+
+	r, err := c.OpenRange(remotePath, offset, -1)
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+
+	if err == nil && offset == 0 {
+		err = f.Truncate(0)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	// The partial download is kept, the next attempt resumes it
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, localPath)
+}
+
+func resumeOffset(tmpPath string, remote *flyclient.FileInfo) int64 {
+	info, err := os.Stat(tmpPath)
+
+	if err != nil || !info.Mode().IsRegular() {
+		return 0
+	}
+
+	if info.Size() > remote.Size() || info.ModTime().Before(remote.ModTime()) {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// Uploads a local file, the remote file is only replaced once the server
+// verified the checksum. When remotePath is a folder, the file is uploaded
+// inside of it.
+func putFile(c *flyclient.Client, localPath string, remotePath string) error {
+	f, err := os.Open(localPath)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil {
+		return err
+	} else if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s: not a regular file", localPath)
+	}
+
+	if info, err := c.Stat(remotePath); err == nil && info.IsDir() {
+		remotePath = path.Join(remotePath, path.Base(localPath))
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	w, err := c.Create(remotePath)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, f); err != nil {
+		w.Abort()
+		return err
+	}
+
+	return w.Close()
+}
@@ -1,58 +1,72 @@
 package main
 
 import (
-	"crypto/tls"
-	"crypto/x509"
-	"log"
-	"net"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
 
-	"github.com/ngagnon/flybywire/internal/wire"
+	"github.com/ngagnon/flybywire/pkg/flyclient"
+	"golang.org/x/term"
 )
 
-func connect(host string, disableTls bool) (net.Conn, error) {
-	if disableTls {
-		return net.Dial("tcp", host)
-	} else {
-		tlsConfig := tls.Config{
-			InsecureSkipVerify: true,
-			VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-				return verifyPeerCertificate(host, rawCerts[0])
-			},
-		}
+var stdin = bufio.NewReader(os.Stdin)
 
-		return tls.Dial("tcp", host, &tlsConfig)
+// Connects with the client library, asking the user to trust the host if needed
+func dialClient(host string, opts flyclient.Options) (c *flyclient.Client, ok bool) {
+	opts.VerifyFingerprint = func(fingerprint string) error {
+		return verifyFingerprint(host, fingerprint)
 	}
+
+	ok = connectTrusted(host, func() (err error) {
+		c, err = flyclient.Dial(host, opts)
+		return err
+	})
+
+	return c, ok
 }
 
-func sendCommand(conn net.Conn, r *wire.WireReader, name string, args ...interface{}) wire.Value {
-	values := make([]wire.Value, len(args)+1)
-	values[0] = wire.NewString(name)
+// Reads a password without echoing it. When stdin isn't a terminal, the
+// password is read from the next line of input, so that scripts can pipe it.
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
 
-	for i, arg := range args {
-		j := i + 1
+	if !term.IsTerminal(fd) {
+		line, err := stdin.ReadString('\n')
 
-		switch v := arg.(type) {
-		case wire.Value:
-			values[j] = v
-		case string:
-			values[j] = wire.NewString(v)
-		default:
-			log.Fatalf("Unsupported array value: %v", arg)
+		if err != nil && (err != io.EOF || line == "") {
+			return "", fmt.Errorf("failed to read password: %w", err)
 		}
+
+		return strings.TrimRight(line, "\r\n"), nil
 	}
 
-	cmd := wire.NewArray(values)
-	err := cmd.WriteTo(conn)
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
 
-	if err != nil {
-		log.Fatalf("Failed to write to socket: %v\n", err)
+	return string(password), err
+}
+
+// Asks for the password twice when stdin is a terminal, to catch typos
+func readNewPassword() (string, error) {
+	password, err := readPassword("New password: ")
+
+	if err != nil || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return password, err
 	}
 
-	res, err := r.Read()
+	confirm, err := readPassword("Confirm password: ")
 
 	if err != nil {
-		log.Fatalf("Failed to read from socket: %v\n", err)
+		return "", err
+	}
+
+	if confirm != password {
+		return "", errors.New("passwords don't match")
 	}
 
-	return res
+	return password, nil
 }
@@ -3,6 +3,8 @@ module github.com/ngagnon/flybywire
 go 1.16
 
 require (
-	github.com/brianvoe/gofakeit/v6 v6.5.0 // indirect
-	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b // indirect
+	github.com/brianvoe/gofakeit/v6 v6.5.0
+	github.com/cespare/xxhash/v2 v2.1.2
+	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
 )
@@ -0,0 +1,93 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+)
+
+const Gzip = "GZIP"
+
+// Upper bound on the number of bytes compression can add to a chunk of
+// incompressible data (gzip header, trailer and stored block headers)
+const Overhead = 64
+
+// Largest amount of data a single chunk can decompress to. Chunks are usually
+// made from 32KB of data or less, this leaves room for peers that use larger ones.
+const MaxDecodedSize = 1024 * 1024
+
+var ErrAlgorithm = errors.New("unsupported compression algorithm")
+var ErrTooLarge = errors.New("chunk decompresses to more than 1MB")
+
+func Supported(algorithm string) bool {
+	return strings.ToUpper(algorithm) == Gzip
+}
+
+// Compresses chunks independently of each other, so that each one can be
+// decompressed on its own.
+type Encoder struct {
+	w *gzip.Writer
+}
+
+type Decoder struct {
+	r *gzip.Reader
+}
+
+func NewEncoder(algorithm string) (*Encoder, error) {
+	if !Supported(algorithm) {
+		return nil, ErrAlgorithm
+	}
+
+	return &Encoder{w: gzip.NewWriter(io.Discard)}, nil
+}
+
+// Compresses data, reusing the memory of dst
+func (e *Encoder) Encode(dst []byte, data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst[:0])
+	e.w.Reset(buf)
+
+	if _, err := e.w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := e.w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func NewDecoder(algorithm string) (*Decoder, error) {
+	if !Supported(algorithm) {
+		return nil, ErrAlgorithm
+	}
+
+	return &Decoder{}, nil
+}
+
+// Decompresses a chunk into w, returns the number of bytes written. Fails with
+// ErrTooLarge once more than MaxDecodedSize bytes were written.
+func (d *Decoder) Decode(w io.Writer, chunk []byte) (int64, error) {
+	var err error
+
+	if d.r == nil {
+		d.r, err = gzip.NewReader(bytes.NewReader(chunk))
+	} else {
+		err = d.r.Reset(bytes.NewReader(chunk))
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	d.r.Multistream(false)
+	n, err := io.Copy(w, io.LimitReader(d.r, MaxDecodedSize+1))
+
+	if err == nil && n > MaxDecodedSize {
+		err = ErrTooLarge
+	}
+
+	return n, err
+}
@@ -0,0 +1,98 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	enc, err := NewEncoder("gzip")
+
+	if err != nil {
+		t.Fatalf("Failed to create encoder: %v", err)
+	}
+
+	dec, err := NewDecoder("gzip")
+
+	if err != nil {
+		t.Fatalf("Failed to create decoder: %v", err)
+	}
+
+	out := new(bytes.Buffer)
+	chunks := [][]byte{
+		bytes.Repeat([]byte("hello,world\n"), 1000),
+		[]byte("some more data\n"),
+	}
+
+	for _, chunk := range chunks {
+		compressed, err := enc.Encode(nil, chunk)
+
+		if err != nil {
+			t.Fatalf("Failed to compress chunk: %v", err)
+		}
+
+		if _, err := dec.Decode(out, compressed); err != nil {
+			t.Fatalf("Failed to decompress chunk: %v", err)
+		}
+	}
+
+	expected := bytes.Join(chunks, nil)
+
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Fatalf("Decompressed data does not match the original")
+	}
+}
+
+func TestOverhead(t *testing.T) {
+	enc, _ := NewEncoder(Gzip)
+	data := make([]byte, 32*1024)
+	rand.Read(data)
+
+	compressed, err := enc.Encode(nil, data)
+
+	if err != nil {
+		t.Fatalf("Failed to compress chunk: %v", err)
+	}
+
+	if len(compressed) > len(data)+Overhead {
+		t.Fatalf("Compressed chunk is %d bytes, expected at most %d", len(compressed), len(data)+Overhead)
+	}
+}
+
+func TestInvalidChunk(t *testing.T) {
+	dec, _ := NewDecoder(Gzip)
+
+	if _, err := dec.Decode(new(bytes.Buffer), []byte("not compressed")); err == nil {
+		t.Fatalf("Expected an error when decompressing invalid data")
+	}
+}
+
+func TestDecodedSizeLimit(t *testing.T) {
+	enc, _ := NewEncoder(Gzip)
+	dec, _ := NewDecoder(Gzip)
+
+	compressed, err := enc.Encode(nil, make([]byte, MaxDecodedSize+1))
+
+	if err != nil {
+		t.Fatalf("Failed to compress chunk: %v", err)
+	}
+
+	if _, err := dec.Decode(io.Discard, compressed); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Expected ErrTooLarge, got %v", err)
+	}
+
+	compressed, _ = enc.Encode(nil, make([]byte, MaxDecodedSize))
+
+	if n, err := dec.Decode(io.Discard, compressed); err != nil || n != MaxDecodedSize {
+		t.Fatalf("Expected %d bytes, got %d (%v)", MaxDecodedSize, n, err)
+	}
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	if _, err := NewEncoder("zstd"); !errors.Is(err, ErrAlgorithm) {
+		t.Fatalf("Expected ErrAlgorithm, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// rsync-style delta encoding. The side holding the old copy of a file sends
+// the signatures of its blocks, the side holding the new copy answers with
+// a sequence of operations: either a reference to one of the old blocks,
+// or literal data that needs to be sent over the wire.
+
+const StrongSize = sha256.Size
+
+type Signature struct {
+	Weak   uint32
+	Strong [StrongSize]byte
+}
+
+type Op struct {
+	Block int
+	Data  []byte
+}
+
+var ErrBlock = errors.New("invalid block reference")
+
+func Signatures(r io.Reader, blockSize int) ([]Signature, error) {
+	sigs := make([]Signature, 0)
+	buf := make([]byte, blockSize)
+
+	for {
+		n, err := io.ReadFull(r, buf)
+
+		if n > 0 {
+			sigs = append(sigs, Signature{
+				Weak:   weakSum(buf[:n]),
+				Strong: sha256.Sum256(buf[:n]),
+			})
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sigs, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Diff reads the new version of a file and emits the operations needed to
+// rebuild it from the blocks described by sigs. Literal ops never exceed
+// maxLiteral bytes, and their data is never reused once emitted.
+func Diff(r io.Reader, blockSize int, sigs []Signature, maxLiteral int, emit func(Op) error) error {
+	index := make(map[uint32][]int, len(sigs))
+
+	for i, s := range sigs {
+		index[s.Weak] = append(index[s.Weak], i)
+	}
+
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	// pending holds the literal data that hasn't been emitted yet, followed by the current window
+	pending := make([]byte, 0, maxLiteral+blockSize)
+	winStart := 0
+	var sum rollingSum
+
+	for {
+		for len(pending)-winStart < blockSize {
+			b, err := br.ReadByte()
+
+			if err == io.EOF {
+				return finishDiff(pending, winStart, sigs, index, maxLiteral, emit)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			pending = append(pending, b)
+		}
+
+		window := pending[winStart:]
+
+		if sum.size == 0 {
+			sum = newRollingSum(window)
+		}
+
+		if block, ok := findBlock(window, sum.value(), sigs, index); ok {
+			if err := emitLiteral(pending[:winStart], maxLiteral, emit); err != nil {
+				return err
+			}
+
+			if err := emit(Op{Block: block}); err != nil {
+				return err
+			}
+
+			pending = make([]byte, 0, maxLiteral+blockSize)
+			winStart = 0
+			sum = rollingSum{}
+			continue
+		}
+
+		b, err := br.ReadByte()
+
+		if err == io.EOF {
+			return finishDiff(pending, winStart, sigs, index, maxLiteral, emit)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		sum.roll(pending[winStart], b)
+		pending = append(pending, b)
+		winStart++
+
+		if winStart >= maxLiteral {
+			if err := emit(Op{Data: pending[:winStart]}); err != nil {
+				return err
+			}
+
+			rest := make([]byte, 0, maxLiteral+blockSize)
+			pending = append(rest, pending[winStart:]...)
+			winStart = 0
+		}
+	}
+}
+
+func finishDiff(pending []byte, winStart int, sigs []Signature, index map[uint32][]int, maxLiteral int, emit func(Op) error) error {
+	// The last block of the old file is usually shorter than the others
+	tail := pending[winStart:]
+
+	if len(tail) > 0 {
+		if block, ok := findBlock(tail, weakSum(tail), sigs, index); ok {
+			if err := emitLiteral(pending[:winStart], maxLiteral, emit); err != nil {
+				return err
+			}
+
+			return emit(Op{Block: block})
+		}
+	}
+
+	return emitLiteral(pending, maxLiteral, emit)
+}
+
+func emitLiteral(data []byte, maxLiteral int, emit func(Op) error) error {
+	for len(data) > 0 {
+		n := len(data)
+
+		if n > maxLiteral {
+			n = maxLiteral
+		}
+
+		if err := emit(Op{Data: data[:n]}); err != nil {
+			return err
+		}
+
+		data = data[n:]
+	}
+
+	return nil
+}
+
+func findBlock(window []byte, weak uint32, sigs []Signature, index map[uint32][]int) (block int, ok bool) {
+	candidates, found := index[weak]
+
+	if !found {
+		return 0, false
+	}
+
+	strong := sha256.Sum256(window)
+
+	for _, i := range candidates {
+		if sigs[i].Strong == strong {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// Apply writes the result of a single operation to w, reading referenced
+// blocks from base (the old copy of the file).
+func Apply(w io.Writer, base io.ReaderAt, blockSize int, op Op) error {
+	if op.Data != nil {
+		_, err := w.Write(op.Data)
+		return err
+	}
+
+	if op.Block < 0 {
+		return fmt.Errorf("%w: %d", ErrBlock, op.Block)
+	}
+
+	buf := make([]byte, blockSize)
+	n, err := base.ReadAt(buf, int64(op.Block)*int64(blockSize))
+
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrBlock, op.Block)
+	}
+
+	_, err = w.Write(buf[:n])
+	return err
+}
+
+// Weak checksum from the rsync paper, which can be updated in constant time
+// when the window slides by one byte.
+type rollingSum struct {
+	a, b uint32
+	size int
+}
+
+func newRollingSum(data []byte) rollingSum {
+	var s rollingSum
+	s.size = len(data)
+
+	for i, c := range data {
+		s.a += uint32(c)
+		s.b += uint32(len(data)-i) * uint32(c)
+	}
+
+	return s
+}
+
+func (s *rollingSum) roll(out byte, in byte) {
+	s.a = s.a - uint32(out) + uint32(in)
+	s.b = s.b - uint32(s.size)*uint32(out) + s.a
+}
+
+func (s *rollingSum) value() uint32 {
+	return (s.a & 0xffff) | (s.b&0xffff)<<16
+}
+
+func weakSum(data []byte) uint32 {
+	s := newRollingSum(data)
+	return s.value()
+}
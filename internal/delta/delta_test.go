@@ -0,0 +1,111 @@
+package delta
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+)
+
+func diff(t *testing.T, old []byte, updated []byte, blockSize int) (ops []Op, patched []byte) {
+	sigs, err := Signatures(bytes.NewReader(old), blockSize)
+
+	if err != nil {
+		t.Fatalf("Failed to compute signatures: %v", err)
+	}
+
+	out := new(bytes.Buffer)
+	base := bytes.NewReader(old)
+
+	err = Diff(bytes.NewReader(updated), blockSize, sigs, 1024, func(op Op) error {
+		ops = append(ops, op)
+		return Apply(out, base, blockSize, op)
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to compute delta: %v", err)
+	}
+
+	return ops, out.Bytes()
+}
+
+func literalSize(ops []Op) int {
+	size := 0
+
+	for _, op := range ops {
+		size += len(op.Data)
+	}
+
+	return size
+}
+
+func TestIdenticalFiles(t *testing.T) {
+	data := []byte(gofakeit.LoremIpsumParagraph(20, 10, 40, "\n"))
+	ops, patched := diff(t, data, data, 128)
+
+	if !bytes.Equal(patched, data) {
+		t.Fatal("The patched file doesn't match the original")
+	}
+
+	if literalSize(ops) != 0 {
+		t.Fatalf("Expected no literal data, got %d bytes", literalSize(ops))
+	}
+}
+
+func TestEmptyBase(t *testing.T) {
+	data := []byte(gofakeit.LoremIpsumParagraph(20, 10, 40, "\n"))
+	ops, patched := diff(t, []byte{}, data, 128)
+
+	if !bytes.Equal(patched, data) {
+		t.Fatal("The patched file doesn't match the original")
+	}
+
+	if literalSize(ops) != len(data) {
+		t.Fatalf("Expected %d bytes of literal data, got %d", len(data), literalSize(ops))
+	}
+
+	for _, op := range ops {
+		if len(op.Data) > 1024 {
+			t.Fatalf("Literal exceeds maximum size: %d", len(op.Data))
+		}
+	}
+}
+
+func TestModifiedMiddle(t *testing.T) {
+	old := []byte(gofakeit.LoremIpsumParagraph(20, 10, 40, "\n"))
+	updated := make([]byte, 0, len(old)+10)
+	updated = append(updated, old[:len(old)/2]...)
+	updated = append(updated, []byte("0123456789")...)
+	updated = append(updated, old[len(old)/2:]...)
+
+	ops, patched := diff(t, old, updated, 128)
+
+	if !bytes.Equal(patched, updated) {
+		t.Fatal("The patched file doesn't match the original")
+	}
+
+	// At most the block where the data was inserted should be resent
+	if literalSize(ops) > 128+10 {
+		t.Fatalf("Expected at most %d bytes of literal data, got %d", 128+10, literalSize(ops))
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	old := []byte(gofakeit.LoremIpsumParagraph(20, 10, 40, "\n"))
+	updated := old[:len(old)-300]
+
+	_, patched := diff(t, old, updated, 128)
+
+	if !bytes.Equal(patched, updated) {
+		t.Fatal("The patched file doesn't match the original")
+	}
+}
+
+func TestInvalidBlock(t *testing.T) {
+	base := bytes.NewReader([]byte("hello"))
+	err := Apply(new(bytes.Buffer), base, 128, Op{Block: 3})
+
+	if err == nil {
+		t.Fatal("Expected an error when referencing a block past the end of the file")
+	}
+}
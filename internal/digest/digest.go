@@ -0,0 +1,59 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+)
+
+const Default = "SHA256"
+
+var ErrAlgorithm = errors.New("unsupported hash algorithm")
+
+var algorithms = map[string]func() hash.Hash{
+	"SHA256": sha256.New,
+	"BLAKE2B": func() hash.Hash {
+		h, _ := blake2b.New512(nil)
+		return h
+	},
+	"XXHASH": func() hash.Hash {
+		return xxhash.New()
+	},
+}
+
+// Returns a new hash for the given algorithm name (case insensitive)
+func New(algorithm string) (h hash.Hash, ok bool) {
+	ctor, ok := algorithms[strings.ToUpper(algorithm)]
+
+	if !ok {
+		return nil, false
+	}
+
+	return ctor(), true
+}
+
+func Supported(algorithm string) bool {
+	_, ok := algorithms[strings.ToUpper(algorithm)]
+	return ok
+}
+
+// Computes the hex-encoded digest of everything that can be read from r
+func Sum(r io.Reader, algorithm string) (string, error) {
+	h, ok := New(algorithm)
+
+	if !ok {
+		return "", ErrAlgorithm
+	}
+
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,55 @@
+package digest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSha256(t *testing.T) {
+	sum, err := Sum(strings.NewReader("hello\n"), "sha256")
+
+	if err != nil {
+		t.Fatalf("Failed to compute digest: %v", err)
+	}
+
+	expected := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+
+	if sum != expected {
+		t.Fatalf("Expected digest to be %s, was %s", expected, sum)
+	}
+}
+
+func TestBlake2b(t *testing.T) {
+	sum, err := Sum(strings.NewReader("hello\n"), "BLAKE2B")
+
+	if err != nil {
+		t.Fatalf("Failed to compute digest: %v", err)
+	}
+
+	if len(sum) != 128 {
+		t.Fatalf("Expected a 512-bit digest, got %s", sum)
+	}
+}
+
+func TestXxhash(t *testing.T) {
+	sum, err := Sum(strings.NewReader("abc"), "xxhash")
+
+	if err != nil {
+		t.Fatalf("Failed to compute digest: %v", err)
+	}
+
+	expected := "44bc2cf5ad770999"
+
+	if sum != expected {
+		t.Fatalf("Expected digest to be %s, was %s", expected, sum)
+	}
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	_, err := Sum(strings.NewReader("hello\n"), "MD4")
+
+	if !errors.Is(err, ErrAlgorithm) {
+		t.Fatalf("Expected ErrAlgorithm, got %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package session
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+// Number of values a stream can have waiting to be written out
+const outboxSize = 2
+
+// Outgoing data of a single stream. The writer takes one value at a time from
+// each stream with pending data, in a round-robin fashion, so that a large
+// transfer can't starve the others.
+type outbox struct {
+	lock    sync.Mutex
+	credit  int
+	limited bool
+	granted chan struct{}
+	slots   chan struct{}
+	held    bool         // only written as part of a Response, see writePart
+	pending []wire.Value // protected by readyLock
+	ready   bool         // protected by readyLock
+}
+
+// Creates an outbox with the credit set by the WINDOW command. When no window
+// has been set, the stream can send as much data as it wants.
+func (s *S) newOutbox() *outbox {
+	s.streamLock.RLock()
+	window := s.window
+	s.streamLock.RUnlock()
+
+	return &outbox{
+		credit:  window,
+		limited: window > 0,
+		granted: make(chan struct{}, 1),
+		slots:   make(chan struct{}, outboxSize),
+	}
+}
+
+// Sets the credit given to streams opened from now on. Zero means no limit.
+func (s *S) SetWindow(window int) {
+	s.streamLock.Lock()
+	s.window = window
+	s.streamLock.Unlock()
+}
+
+// Allows the stream to send n more bytes. Returns false if the stream doesn't exist.
+func (s *S) AddCredit(id int, n int) bool {
+	stream, ok := s.getStream(id)
+
+	if !ok {
+		return false
+	}
+
+	switch st := stream.(type) {
+	case *readStream:
+		st.out.grant(n)
+	case *syncStream:
+		st.out.grant(n)
+	}
+
+	return true
+}
+
+func (o *outbox) grant(n int) {
+	o.lock.Lock()
+	o.credit += n
+	o.lock.Unlock()
+
+	select {
+	case o.granted <- struct{}{}:
+	default:
+	}
+}
+
+// Waits until the stream has credit left, then returns how many bytes (up to max)
+// it can send. Returns false if the stream was cancelled while waiting.
+func (s *S) acquire(o *outbox, max int, cancel <-chan struct{}) (n int, ok bool) {
+	if !o.limited {
+		return max, true
+	}
+
+	for {
+		o.lock.Lock()
+		credit := o.credit
+		o.lock.Unlock()
+
+		if credit >= max {
+			return max, true
+		}
+
+		if credit > 0 {
+			return credit, true
+		}
+
+		select {
+		case <-o.granted:
+		case <-cancel:
+			return 0, false
+		case <-s.done:
+			return 0, false
+		}
+	}
+}
+
+// Queues a value to be written out, waiting if the stream already has too many
+// values pending. Once this returns, the buffer of the value that was queued
+// outboxSize calls earlier can be reused. Size is the number of bytes taken off
+// the stream's credit.
+func (s *S) send(o *outbox, val wire.Value, size int, cancel <-chan struct{}) bool {
+	select {
+	case o.slots <- struct{}{}:
+	case <-cancel:
+		return false
+	case <-s.done:
+		return false
+	}
+
+	if o.limited {
+		o.lock.Lock()
+		o.credit -= size
+		o.lock.Unlock()
+	}
+
+	s.readyLock.Lock()
+	o.pending = append(o.pending, val)
+
+	if !o.ready && !o.held {
+		o.ready = true
+		s.ready = append(s.ready, o)
+	}
+
+	s.readyLock.Unlock()
+
+	s.wakeWriter()
+	return true
+}
+
+// Waits until the writer has written out every value queued by the stream.
+// Until then, the stream's ID must not be released: the client could otherwise
+// receive those values after the ID was given to another stream (or after
+// CLOSE returned).
+func (s *S) drain(o *outbox) {
+	for i := 0; i < outboxSize; i++ {
+		select {
+		case o.slots <- struct{}{}:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *S) wakeWriter() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Writes out the next value of the stream at the front of the queue, then moves
+// that stream to the back of the queue if it has more values pending
+func (s *S) writeNext(w *wire.WireWriter) error {
+	s.readyLock.Lock()
+
+	if len(s.ready) == 0 {
+		s.readyLock.Unlock()
+		return nil
+	}
+
+	o := s.ready[0]
+	s.ready[0] = nil
+	s.ready = s.ready[1:]
+
+	val := o.pending[0]
+	o.pending[0] = nil
+	o.pending = o.pending[1:]
+
+	if len(o.pending) > 0 {
+		s.ready = append(s.ready, o)
+	} else {
+		o.ready = false
+	}
+
+	more := len(s.ready) > 0
+	s.readyLock.Unlock()
+
+	if more {
+		s.wakeWriter()
+	}
+
+	err := w.Write(val)
+	<-o.slots
+	runtime.Gosched()
+
+	return err
+}
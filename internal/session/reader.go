@@ -13,6 +13,10 @@ import (
 )
 
 var outMarker = wire.NewString("OUT")
+var tagMarker = wire.NewString("TAG")
+
+// Shared by all sessions, the blobs are released once written to disk
+var blobPool = wire.NewBlobPool(MaxBlobSize)
 
 func handleReads(conn net.Conn, s *S) {
 	s.waitGroup.Add(1)
@@ -20,7 +24,8 @@ func handleReads(conn net.Conn, s *S) {
 
 	bufReader := bufio.NewReader(conn)
 	reader := wire.NewReader(bufReader)
-	reader.MaxBlobSize = 32 * 1024
+	reader.MaxBlobSize = MaxBlobSize
+	reader.Pool = blobPool
 
 	var err error
 
@@ -47,7 +52,12 @@ func handleReads(conn net.Conn, s *S) {
 		}
 
 		if tagged, isTagged := value.(*wire.TaggedValue); isTagged {
-			handleStreamFrame(tagged, s)
+			if isRequestTag(tagged.Tag) {
+				handleTaggedCommand(tagged, s)
+			} else {
+				handleStreamFrame(tagged, s)
+			}
+
 			continue
 		}
 
@@ -76,11 +86,40 @@ func handleCommand(array *wire.Array, s *S) {
 	s.commands <- array
 }
 
+// Stream IDs are numeric, so any other tag is a request ID
+func isRequestTag(tag string) bool {
+	_, err := strconv.Atoi(tag)
+	return err != nil
+}
+
+func handleTaggedCommand(tagged *wire.TaggedValue, s *S) {
+	array, isArray := tagged.Value.(*wire.Array)
+
+	if !isArray {
+		s.streamError("PROTO", fmt.Sprintf("Protocol error: unexpected %s, was expecting command", tagged.Value.Name()), tagged.Tag)
+		return
+	}
+
+	if len(array.Values) == 0 {
+		s.streamError("PROTO", "Protocol error: unexpected empty array", tagged.Tag)
+		return
+	}
+
+	if _, ok := array.Values[0].(*wire.String); !ok {
+		s.streamError("PROTO", fmt.Sprintf("Protocol error: command name should be a string, was %s", array.Values[0].Name()), tagged.Tag)
+		return
+	}
+
+	s.commands <- wire.NewArray([]wire.Value{tagMarker, tagged})
+}
+
 func handleStreamFrame(tagged *wire.TaggedValue, s *S) {
 	payload := tagged.Value
 	blob, isBlob := payload.(*wire.Blob)
+	checksum, isChecksum := payload.(*wire.String)
+	block, isBlock := payload.(*wire.Integer)
 
-	if !isBlob && payload != wire.Null {
+	if !isBlob && !isChecksum && !isBlock && payload != wire.Null {
 		s.protocolError("invalid stream frame, unexpected %s", payload.Name())
 		return
 	}
@@ -106,8 +145,17 @@ func handleStreamFrame(tagged *wire.TaggedValue, s *S) {
 
 	writeStream := stream.(*writeStream)
 
+	if isBlock && writeStream.blockSize == 0 {
+		s.streamError("ARG", "Block references are only allowed in delta streams", tagged.Tag)
+		return
+	}
+
 	if isBlob {
-		writeStream.frames <- newDataFrame(blob.Data)
+		writeStream.frames <- newDataFrame(blob)
+	} else if isBlock {
+		writeStream.frames <- newBlockFrame(int(block.Value))
+	} else if isChecksum {
+		writeStream.frames <- newChecksumFrame(checksum.Value)
 	} else {
 		writeStream.frames <- newFinishFrame()
 	}
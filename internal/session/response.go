@@ -0,0 +1,104 @@
+package session
+
+import (
+	"io"
+
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+// A response that is written out in parts while it's being produced, for
+// values too large to be encoded at once (e.g. a streamed table). The command
+// handler returns the Response, then queues the encoded parts with Send and
+// End from another goroutine. Once the writer reaches the response, it writes
+// the parts as they come, and nothing else until the last one.
+type Response struct {
+	s   *S
+	out *outbox
+}
+
+type part struct {
+	data []byte
+	last bool
+}
+
+func (s *S) NewResponse() *Response {
+	return &Response{
+		s: s,
+		out: &outbox{
+			held:  true,
+			slots: make(chan struct{}, outboxSize),
+		},
+	}
+}
+
+// Queues the next part of the response. Returns false if the session ended.
+func (r *Response) Send(data []byte) bool {
+	return r.send(data, false)
+}
+
+// Queues the last part of the response
+func (r *Response) End(data []byte) bool {
+	return r.send(data, true)
+}
+
+func (r *Response) send(data []byte, last bool) bool {
+	p := &part{data: append([]byte(nil), data...), last: last}
+	return r.s.send(r.out, p, 0, nil)
+}
+
+// The parts are written by the session's writer, see writePart
+func (r *Response) WriteTo(w io.Writer) (int64, error) {
+	return 0, nil
+}
+
+func (r *Response) Name() string {
+	return "response"
+}
+
+func (p *part) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(p.data)
+	return int64(n), err
+}
+
+func (p *part) Name() string {
+	return "part"
+}
+
+// Writes out the next part of the response being written, or waits for it
+func (s *S) writePart(w *wire.WireWriter) error {
+	o := s.response.out
+	s.readyLock.Lock()
+
+	if len(o.pending) == 0 {
+		s.readyLock.Unlock()
+
+		if w.Buffered() > 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-s.done:
+			return done
+		case <-s.wake:
+			return nil
+		}
+	}
+
+	p := o.pending[0].(*part)
+	o.pending[0] = nil
+	o.pending = o.pending[1:]
+	s.readyLock.Unlock()
+
+	err := w.Write(p)
+	<-o.slots
+
+	// The streams may have queued values in the meantime
+	if p.last {
+		s.response = nil
+		s.wakeWriter()
+	}
+
+	return err
+}
@@ -3,6 +3,7 @@ package session
 import (
 	"net"
 	"sync"
+	"sync/atomic"
 
 	"github.com/ngagnon/flybywire/internal/wire"
 )
@@ -11,24 +12,54 @@ type S struct {
 	terminate   chan struct{}
 	waitGroup   *sync.WaitGroup
 	done        chan struct{}
-	dataOut     chan wire.Value
 	cmdOut      chan wire.Value
 	commands    chan *wire.Array
-	streams     [16]stream
+	streams     map[int]stream
+	freeIds     []int
+	nextId      int
 	streamLock  sync.RWMutex
-	streamCount int
+	window      int
+	ready       []*outbox
+	readyLock   sync.Mutex
+	wake        chan struct{}
+	response    *Response // being written out, only used by the writer
+	tagged      sync.WaitGroup
+	taggedSlots chan struct{}
+	extensions  map[string]bool
+	extLock     sync.RWMutex
 }
 
 type CommandHandler func(cmd *wire.Array, s *S) (response wire.Value)
 
+const (
+	DefaultMaxStreams       = 256
+	DefaultMaxServerStreams = 4096
+)
+
+// Largest blob accepted in a stream frame
+const MaxBlobSize = 32 * 1024
+
+var maxSessionStreams = DefaultMaxStreams
+var maxServerStreams = DefaultMaxServerStreams
+var serverStreams int64
+
+// Sets how many streams can be open at once, for a single session and for
+// the whole server. Zero means no limit.
+func SetStreamLimits(perSession int, total int) {
+	maxSessionStreams = perSession
+	maxServerStreams = total
+}
+
 func Handle(conn net.Conn, cb CommandHandler) {
 	session := &S{
-		terminate: make(chan struct{}, 3),
-		done:      make(chan struct{}),
-		waitGroup: &sync.WaitGroup{},
-		dataOut:   make(chan wire.Value), // must be blocking! (handleReadStream assumes this)
-		cmdOut:    make(chan wire.Value, 5),
-		commands:  make(chan *wire.Array, 5),
+		terminate:   make(chan struct{}, 3),
+		done:        make(chan struct{}),
+		waitGroup:   &sync.WaitGroup{},
+		cmdOut:      make(chan wire.Value, 5),
+		commands:    make(chan *wire.Array, 5),
+		streams:     make(map[int]stream),
+		wake:        make(chan struct{}, 1),
+		taggedSlots: make(chan struct{}, maxTaggedCommands),
 	}
 
 	go handleReads(conn, session)
@@ -44,3 +75,47 @@ func Handle(conn net.Conn, cb CommandHandler) {
 func (s *S) Terminate() {
 	s.terminate <- struct{}{}
 }
+
+// Closed once the session has ended
+func (s *S) Done() <-chan struct{} {
+	return s.done
+}
+
+// Returns the maximum number of streams the session can have open at once (0 for no limit)
+func (s *S) MaxStreams() int {
+	return maxSessionStreams
+}
+
+// Records the protocol extensions that were negotiated with the client
+func (s *S) SetExtensions(names []string) {
+	extensions := make(map[string]bool)
+
+	for _, name := range names {
+		extensions[name] = true
+	}
+
+	s.extLock.Lock()
+	s.extensions = extensions
+	s.extLock.Unlock()
+}
+
+func (s *S) HasExtension(name string) bool {
+	s.extLock.RLock()
+	defer s.extLock.RUnlock()
+	return s.extensions[name]
+}
+
+func acquireServerStream() bool {
+	n := atomic.AddInt64(&serverStreams, 1)
+
+	if maxServerStreams > 0 && n > int64(maxServerStreams) {
+		atomic.AddInt64(&serverStreams, -1)
+		return false
+	}
+
+	return true
+}
+
+func releaseServerStream() {
+	atomic.AddInt64(&serverStreams, -1)
+}
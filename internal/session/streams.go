@@ -2,12 +2,17 @@ package session
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ngagnon/flybywire/internal/compress"
+	"github.com/ngagnon/flybywire/internal/delta"
+	"github.com/ngagnon/flybywire/internal/digest"
 	log "github.com/ngagnon/flybywire/internal/logging"
 	"github.com/ngagnon/flybywire/internal/wire"
 )
@@ -25,17 +30,23 @@ const (
 	read mode = iota
 	write
 	copy
+	diff
 )
 
 type frame struct {
-	end     bool
-	payload []byte
+	end      bool
+	payload  *wire.Blob
+	block    int // copied from the base file when there's no payload
+	checksum string
 }
 
 type readStream struct {
-	cancel chan struct{}
-	done   chan struct{}
-	file   *os.File
+	cancel  chan struct{}
+	done    chan struct{}
+	file    *os.File
+	reader  io.Reader
+	out     *outbox
+	encoder *compress.Encoder
 }
 
 type writeStream struct {
@@ -44,6 +55,11 @@ type writeStream struct {
 	done      chan struct{}
 	finalPath string
 	file      *os.File
+	upload    *upload
+	base      *os.File // the file being replaced, for delta streams
+	blockSize int      // only set for delta streams
+	decoder   *compress.Decoder
+	out       *outbox
 }
 
 type copyStream struct {
@@ -51,6 +67,16 @@ type copyStream struct {
 	done   chan struct{}
 	src    string
 	dst    string
+	out    *outbox
+}
+
+type syncStream struct {
+	cancel    chan struct{}
+	done      chan struct{}
+	file      *os.File
+	blockSize int
+	sigs      []delta.Signature
+	out       *outbox
 }
 
 type stream interface {
@@ -58,7 +84,20 @@ type stream interface {
 	mode() mode
 }
 
-func (s *S) NewReadStream(path string) (id int, wirErr *wire.Error) {
+// Opens a stream that sends the file starting at the given offset. When length
+// is negative, the stream goes on until the end of the file. When compression
+// is not empty, each chunk is compressed with that algorithm.
+func (s *S) NewReadStream(path string, offset int64, length int64, compression string) (id int, wireErr *wire.Error) {
+	var encoder *compress.Encoder
+
+	if compression != "" {
+		var err error
+
+		if encoder, err = compress.NewEncoder(compression); err != nil {
+			return 0, wire.NewError("ARG", "Unsupported compression: %s", compression)
+		}
+	}
+
 	file, err := os.Open(path)
 
 	if errors.Is(err, os.ErrNotExist) {
@@ -70,104 +109,304 @@ func (s *S) NewReadStream(path string) (id int, wirErr *wire.Error) {
 		return 0, wire.NewError("ERR", "Unexpected error occurred")
 	}
 
-	s.streamLock.Lock()
-	defer s.streamLock.Unlock()
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		log.Debugf("Could not seek file: %v", err)
+		file.Close()
+		return 0, wire.NewError("ERR", "Unexpected error occurred")
+	}
 
-	id, ok := nextStreamId(s.streams[:])
+	var reader io.Reader = file
 
-	if !ok {
-		file.Close()
-		return 0, wire.NewError("TOOMANY", "Too many streams open")
+	if length >= 0 {
+		reader = io.LimitReader(file, length)
 	}
 
 	stream := &readStream{
-		cancel: make(chan struct{}, 2),
-		done:   make(chan struct{}),
-		file:   file,
+		cancel:  make(chan struct{}, 2),
+		done:    make(chan struct{}),
+		file:    file,
+		reader:  reader,
+		out:     s.newOutbox(),
+		encoder: encoder,
+	}
+
+	id, wireErr = s.addStream(stream)
+
+	if wireErr != nil {
+		file.Close()
+		return 0, wireErr
 	}
 
-	s.streams[id] = stream
-	s.streamCount++
 	go handleReadStream(id, stream, s)
 
 	return id, nil
 }
 
-func (s *S) NewWriteStream(finalPath string) (id int, wireErr *wire.Error) {
+// When compression is not empty, the chunks are decompressed with that algorithm
+// before being written to disk.
+func (s *S) NewWriteStream(finalPath string, compression string) (id int, wireErr *wire.Error) {
+	decoder, wireErr := newDecoder(compression)
+
+	if wireErr != nil {
+		return 0, wireErr
+	}
+
+	if wireErr := checkWritePath(finalPath); wireErr != nil {
+		return 0, wireErr
+	}
+
+	file, err := createStagingFile(finalPath)
+
+	if err != nil {
+		log.Debugf("Could not create staging file: %v", err)
+		return 0, wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	id, wireErr = s.addWriteStream(&writeStream{
+		finalPath: finalPath,
+		file:      file,
+		decoder:   decoder,
+	})
+
+	if wireErr != nil {
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	return id, wireErr
+}
+
+// Opens a write stream that receives the file as a delta against basePath (see
+// SYNC), usually the file being replaced. Returns the signatures of its blocks,
+// of which there are none when basePath is empty or doesn't exist.
+func (s *S) NewDeltaWriteStream(finalPath string, basePath string, blockSize int, compression string) (id int, sigs []delta.Signature, wireErr *wire.Error) {
+	decoder, wireErr := newDecoder(compression)
+
+	if wireErr != nil {
+		return 0, nil, wireErr
+	}
+
+	if wireErr := checkWritePath(finalPath); wireErr != nil {
+		return 0, nil, wireErr
+	}
+
+	base, sigs, err := openBase(basePath, blockSize)
+
+	if err != nil {
+		log.Debugf("Could not compute signatures: %v", err)
+		return 0, nil, wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	file, err := createStagingFile(finalPath)
+
+	if err != nil {
+		log.Debugf("Could not create staging file: %v", err)
+		closeBase(base)
+		return 0, nil, wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	id, wireErr = s.addWriteStream(&writeStream{
+		finalPath: finalPath,
+		file:      file,
+		base:      base,
+		blockSize: blockSize,
+		decoder:   decoder,
+	})
+
+	if wireErr != nil {
+		file.Close()
+		os.Remove(file.Name())
+		closeBase(base)
+		return 0, nil, wireErr
+	}
+
+	return id, sigs, nil
+}
+
+func openBase(path string, blockSize int) (*os.File, []delta.Signature, error) {
+	if path == "" {
+		return nil, []delta.Signature{}, nil
+	}
+
+	f, err := os.Open(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, []delta.Signature{}, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sigs, err := delta.Signatures(f, blockSize)
+
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, sigs, nil
+}
+
+// There is no base when the file didn't exist
+func closeBase(base *os.File) {
+	if base != nil {
+		base.Close()
+	}
+}
+
+// Opens a write stream whose partial contents are kept when interrupted. Pass an
+// empty token to start a new upload, or the token of an interrupted upload to
+// append to it, which must have been started by the same user. Returns the
+// number of bytes already received.
+func (s *S) NewResumableWriteStream(finalPath string, token string, username string, compression string) (id int, newToken string, offset int64, wireErr *wire.Error) {
+	decoder, wireErr := newDecoder(compression)
+
+	if wireErr != nil {
+		return 0, "", 0, wireErr
+	}
+
+	if wireErr := checkWritePath(finalPath); wireErr != nil {
+		return 0, "", 0, wireErr
+	}
+
+	u, file, wireErr := beginUpload(finalPath, token, username)
+
+	if wireErr != nil {
+		return 0, "", 0, wireErr
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		log.Debugf("Could not stat partial upload: %v", err)
+		file.Close()
+		u.suspend()
+		return 0, "", 0, wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	id, wireErr = s.addWriteStream(&writeStream{
+		finalPath: finalPath,
+		file:      file,
+		upload:    u,
+		decoder:   decoder,
+	})
+
+	if wireErr != nil {
+		file.Close()
+		u.suspend()
+		return 0, "", 0, wireErr
+	}
+
+	return id, u.token, info.Size(), nil
+}
+
+func checkWritePath(finalPath string) *wire.Error {
 	parentFolder := filepath.Dir(finalPath)
 	info, err := os.Stat(parentFolder)
 
 	if errors.Is(err, os.ErrNotExist) || !info.IsDir() {
-		return 0, wire.NewError("NOTFOUND", "No such file or directory")
+		return wire.NewError("NOTFOUND", "No such file or directory")
 	}
 
 	info, err = os.Stat(finalPath)
 
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		log.Debugf("Could not stat file for writing: %v", err)
-		return 0, wire.NewError("ERR", "Unexpected error occurred")
+		return wire.NewError("ERR", "Unexpected error occurred")
 	}
 
 	if err == nil && !info.Mode().IsRegular() {
-		return 0, wire.NewError("ILLEGAL", "Not a regular file")
+		return wire.NewError("ILLEGAL", "Not a regular file")
+	}
+
+	return nil
+}
+
+func newDecoder(compression string) (*compress.Decoder, *wire.Error) {
+	if compression == "" {
+		return nil, nil
 	}
 
-	file, err := os.CreateTemp("", "flytmp")
+	decoder, err := compress.NewDecoder(compression)
 
 	if err != nil {
-		log.Debugf("Could not create temporary directory: %v", err)
-		return 0, wire.NewError("ERR", "Unexpected error occurred")
+		return nil, wire.NewError("ARG", "Unsupported compression: %s", compression)
 	}
 
-	s.streamLock.Lock()
-	defer s.streamLock.Unlock()
+	return decoder, nil
+}
 
-	id, ok := nextStreamId(s.streams[:])
+func (s *S) addWriteStream(stream *writeStream) (id int, wireErr *wire.Error) {
+	stream.frames = make(chan frame, 5)
+	stream.cancel = make(chan struct{}, 2)
+	stream.done = make(chan struct{})
+	stream.out = s.newOutbox()
 
-	if !ok {
-		file.Close()
-		return 0, wire.NewError("TOOMANY", "Too many streams open")
-	}
+	id, wireErr = s.addStream(stream)
 
-	stream := &writeStream{
-		frames:    make(chan frame, 5),
-		cancel:    make(chan struct{}, 2),
-		done:      make(chan struct{}),
-		finalPath: finalPath,
-		file:      file,
+	if wireErr != nil {
+		return 0, wireErr
 	}
 
-	s.streams[id] = stream
-	s.streamCount++
 	go handleWriteStream(id, stream, s)
 
 	return id, nil
 }
 
 func (s *S) NewCopyStream(src string, dst string) (id int, wireErr *wire.Error) {
-	s.streamLock.Lock()
-	defer s.streamLock.Unlock()
-
-	id, ok := nextStreamId(s.streams[:])
-
-	if !ok {
-		return 0, wire.NewError("TOOMANY", "Too many streams open")
-	}
-
 	stream := &copyStream{
 		cancel: make(chan struct{}, 2),
 		done:   make(chan struct{}),
 		src:    src,
 		dst:    dst,
+		out:    s.newOutbox(),
+	}
+
+	id, wireErr = s.addStream(stream)
+
+	if wireErr != nil {
+		return 0, wireErr
 	}
 
-	s.streams[id] = stream
-	s.streamCount++
 	go handleCopyStream(id, stream, s)
 
 	return id, nil
 }
 
+func (s *S) NewSyncStream(path string, blockSize int, sigs []delta.Signature) (id int, wireErr *wire.Error) {
+	file, err := os.Open(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, wire.NewError("NOTFOUND", "No such file or directory")
+	}
+
+	if err != nil {
+		log.Debugf("Could not open file for syncing: %v", err)
+		return 0, wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	stream := &syncStream{
+		cancel:    make(chan struct{}, 2),
+		done:      make(chan struct{}),
+		file:      file,
+		blockSize: blockSize,
+		sigs:      sigs,
+		out:       s.newOutbox(),
+	}
+
+	id, wireErr = s.addStream(stream)
+
+	if wireErr != nil {
+		file.Close()
+		return 0, wireErr
+	}
+
+	go handleSyncStream(id, stream, s)
+
+	return id, nil
+}
+
 func (s *S) CloseStream(id int) bool {
 	stream, ok := s.getStream(id)
 
@@ -183,54 +422,79 @@ func (s *S) CloseStream(id int) bool {
 func (s *S) NumStreams() int {
 	s.streamLock.RLock()
 	defer s.streamLock.RUnlock()
-	return s.streamCount
+	return len(s.streams)
+}
+
+// Registers the stream under a new ID, as long as neither the session nor
+// the server has reached its stream limit
+func (s *S) addStream(stream stream) (id int, wireErr *wire.Error) {
+	s.streamLock.Lock()
+	defer s.streamLock.Unlock()
+
+	if maxSessionStreams > 0 && len(s.streams) >= maxSessionStreams {
+		return 0, wire.NewError("TOOMANY", "Too many streams open")
+	}
+
+	if !acquireServerStream() {
+		return 0, wire.NewError("TOOMANY", "Too many streams open on the server")
+	}
+
+	if n := len(s.freeIds); n > 0 {
+		id = s.freeIds[n-1]
+		s.freeIds = s.freeIds[:n-1]
+	} else {
+		id = s.nextId
+		s.nextId++
+	}
+
+	s.streams[id] = stream
+	return id, nil
 }
 
 func (s *S) releaseStream(id int) {
 	s.streamLock.Lock()
-	s.streams[id] = nil
-	s.streamCount--
+	delete(s.streams, id)
+	s.freeIds = append(s.freeIds, id)
 	s.streamLock.Unlock()
+
+	releaseServerStream()
 }
 
 func (s *S) getStream(id int) (stream stream, ok bool) {
 	s.streamLock.RLock()
 	defer s.streamLock.RUnlock()
 
-	if id < 0 || id >= len(s.streams) {
-		return nil, false
-	}
-
-	stream = s.streams[id]
-	ok = stream != nil
+	stream, ok = s.streams[id]
 	return
 }
 
-func nextStreamId(streams []stream) (id int, ok bool) {
-	for i := 0; i < len(streams); i++ {
-		if streams[i] == nil {
-			return i, true
-		}
-	}
-
-	return 0, false
-}
-
 func handleReadStream(id int, s *readStream, session *S) {
 	defer session.releaseStream(id)
 	defer s.file.Close()
 	defer close(s.done)
+	defer session.drain(s.out)
 
 	session.waitGroup.Add(1)
 	defer session.waitGroup.Done()
 
 	tag := strconv.Itoa(id)
 
-	// While one buffer is being written out to the network, we'll start reading into the other buffer
-	buf := make([][]byte, 2)
-	buf[0] = make([]byte, 32*1024)
-	buf[1] = make([]byte, 32*1024)
+	// While the previous chunks are waiting to be written out to the network, we'll start reading into another buffer
+	buf := make([][]byte, outboxSize+1)
+
+	for i := range buf {
+		buf[i] = make([]byte, MaxBlobSize)
+	}
+
 	cur := 0
+	chunkSize := MaxBlobSize
+	var raw []byte
+
+	// Leave room for the compressed chunk to be a bit larger than the original
+	if s.encoder != nil {
+		chunkSize -= compress.Overhead
+		raw = make([]byte, chunkSize)
+	}
 
 	for {
 		select {
@@ -241,29 +505,55 @@ func handleReadStream(id int, s *readStream, session *S) {
 		default:
 		}
 
-		cur = (cur + 1) % 2
-		n, err := s.file.Read(buf[cur])
+		size, ok := session.acquire(s.out, chunkSize, s.cancel)
+
+		if !ok {
+			return
+		}
+
+		cur = (cur + 1) % len(buf)
+		chunk := buf[cur][:size]
+
+		if s.encoder != nil {
+			chunk = raw[:size]
+		}
+
+		n, err := s.reader.Read(chunk)
 
 		if err == io.EOF {
-			session.dataOut <- wire.NewTaggedValue(wire.Null, tag)
+			session.send(s.out, wire.NewTaggedValue(wire.Null, tag), 0, s.cancel)
 			return
 		}
 
 		if err != nil {
 			log.Debugf("Could not read from file: %v", err)
 			wireErr := wire.NewError("IO", "Could not read chunk from file. Closing stream.")
-			session.dataOut <- wire.NewTaggedValue(wireErr, tag)
+			session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, s.cancel)
 			return
 		}
 
-		blob := wire.NewBlob(buf[cur][0:n])
-		session.dataOut <- wire.NewTaggedValue(blob, tag)
+		chunk = chunk[:n]
+
+		if s.encoder != nil {
+			if chunk, err = s.encoder.Encode(buf[cur], chunk); err != nil {
+				log.Debugf("Could not compress chunk: %v", err)
+				wireErr := wire.NewError("IO", "Could not compress chunk. Closing stream.")
+				session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, s.cancel)
+				return
+			}
+		}
+
+		if !session.send(s.out, wire.NewTaggedValue(wire.NewBlob(chunk), tag), len(chunk), s.cancel) {
+			return
+		}
 	}
 }
 
 func handleWriteStream(id int, s *writeStream, session *S) {
 	defer session.releaseStream(id)
 	defer close(s.done)
+	defer closeBase(s.base)
+	defer session.drain(s.out)
 
 	session.waitGroup.Add(1)
 	defer session.waitGroup.Done()
@@ -296,11 +586,17 @@ func handleWriteStream(id int, s *writeStream, session *S) {
 			handleTimeout(s, session, tag)
 			return
 		case frame := <-s.frames:
-			if frame.end {
-				finishWriteStream(s, tag, session)
+			switch {
+			case frame.end:
+				finishWriteStream(s, tag, frame.checksum, session)
 				return
-			} else {
-				ok := handleChunk(frame.payload, tag, s, session, watchdog)
+			case frame.payload == nil:
+				if !handleBlock(frame.block, tag, s, session, watchdog) {
+					return
+				}
+			default:
+				ok := handleChunk(frame.payload.Data, tag, s, session, watchdog)
+				frame.payload.Release()
 
 				if !ok {
 					return
@@ -313,6 +609,7 @@ func handleWriteStream(id int, s *writeStream, session *S) {
 func handleCopyStream(id int, s *copyStream, session *S) {
 	defer session.releaseStream(id)
 	defer close(s.done)
+	defer session.drain(s.out)
 
 	session.waitGroup.Add(1)
 	defer session.waitGroup.Done()
@@ -323,22 +620,22 @@ func handleCopyStream(id int, s *copyStream, session *S) {
 	if err != nil {
 		log.Debugf("Could not open file: %v", err)
 		wireErr := wire.NewError("IO", "Could not open source file. Closing stream.")
-		session.dataOut <- wire.NewTaggedValue(wireErr, tag)
+		session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, nil)
 		return
 	}
 
 	defer src.Close()
 
-	tmp, err := os.CreateTemp("", "flytmp")
+	tmp, err := createStagingFile(s.dst)
 
 	if err != nil {
-		log.Debugf("Could not create temporary file: %v", err)
-		wireErr := wire.NewError("IO", "Could not create temporary. Closing stream.")
-		session.dataOut <- wire.NewTaggedValue(wireErr, tag)
+		log.Debugf("Could not create staging file: %v", err)
+		wireErr := wire.NewError("IO", "Could not create temporary file. Closing stream.")
+		session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, nil)
 		return
 	}
 
-	buf := make([]byte, 32*1024)
+	buf := make([]byte, MaxBlobSize)
 
 	for {
 		select {
@@ -359,37 +656,124 @@ func handleCopyStream(id int, s *copyStream, session *S) {
 			if err = os.Rename(tmp.Name(), s.dst); err != nil {
 				log.Debugf("Could not move temporary file to final destination: %v", err)
 				wireErr := wire.NewError("IO", "Could not move temporary file to final destination. Closing stream.")
-				session.dataOut <- wire.NewTaggedValue(wireErr, tag)
+				session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, nil)
 				return
 			}
 
-			session.dataOut <- wire.NewTaggedValue(wire.Null, tag)
+			session.send(s.out, wire.NewTaggedValue(wire.Null, tag), 0, nil)
 			return
 		}
 
 		if err != nil {
 			log.Debugf("Could not copy chunk: %v", err)
 			wireErr := wire.NewError("IO", "Could not copy chunk of data. Closing stream.")
-			session.dataOut <- wire.NewTaggedValue(wireErr, tag)
+			session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, nil)
 			return
 		}
 	}
 }
 
+var errCancelled = errors.New("cancelled")
+
+func handleSyncStream(id int, s *syncStream, session *S) {
+	defer session.releaseStream(id)
+	defer s.file.Close()
+	defer close(s.done)
+	defer session.drain(s.out)
+
+	session.waitGroup.Add(1)
+	defer session.waitGroup.Done()
+
+	tag := strconv.Itoa(id)
+
+	err := delta.Diff(s.file, s.blockSize, s.sigs, MaxBlobSize, func(op delta.Op) error {
+		if op.Data == nil {
+			if !session.send(s.out, wire.NewTaggedValue(wire.NewInteger(int64(op.Block)), tag), 0, s.cancel) {
+				return errCancelled
+			}
+
+			return nil
+		}
+
+		// Literals are split up to fit within the stream's credit
+		for data := op.Data; len(data) > 0; {
+			n, ok := session.acquire(s.out, len(data), s.cancel)
+
+			if !ok || !session.send(s.out, wire.NewTaggedValue(wire.NewBlob(data[:n]), tag), n, s.cancel) {
+				return errCancelled
+			}
+
+			data = data[n:]
+		}
+
+		return nil
+	})
+
+	if err == errCancelled {
+		return
+	}
+
+	if err != nil {
+		log.Debugf("Could not compute delta: %v", err)
+		wireErr := wire.NewError("IO", "Could not read chunk from file. Closing stream.")
+		session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, s.cancel)
+		return
+	}
+
+	session.send(s.out, wire.NewTaggedValue(wire.Null, tag), 0, s.cancel)
+}
+
 func handleTimeout(s *writeStream, session *S, tag string) {
 	cancelWriteStream(s)
 	err := wire.NewError("TIMEOUT", "Timed out due to inactivity")
-	session.dataOut <- wire.NewTaggedValue(err, tag)
+	session.send(s.out, wire.NewTaggedValue(err, tag), 0, nil)
 }
 
 func handleChunk(chunk []byte, tag string, s *writeStream, session *S, wd *watchdog) bool {
-	_, err := s.file.Write(chunk)
+	err := s.write(chunk)
+
+	if errors.Is(err, errCorrupt) {
+		log.Debugf("Could not decompress chunk: %v", err)
+		cancelWriteStream(s)
+		wireErr := wire.NewError("ARG", "Could not decompress chunk. Closing stream.")
+		session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, nil)
+		return false
+	}
 
 	if err != nil {
 		log.Debugf("Could not write file to disk: %v", err)
 		cancelWriteStream(s)
 		wireErr := wire.NewError("IO", "Could not write chunk to disk. Closing stream.")
-		session.dataOut <- wire.NewTaggedValue(wireErr, tag)
+		session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, nil)
+		return false
+	}
+
+	wd.reset()
+
+	return true
+}
+
+// Copies a block of the base file, for delta streams
+func handleBlock(block int, tag string, s *writeStream, session *S, wd *watchdog) bool {
+	err := fmt.Errorf("%w: %d", delta.ErrBlock, block)
+
+	if s.base != nil {
+		err = delta.Apply(s.file, s.base, s.blockSize, delta.Op{Block: block})
+	}
+
+	if errors.Is(err, delta.ErrBlock) {
+		log.Debugf("Could not copy block: %v", err)
+		cancelWriteStream(s)
+		wireErr := wire.NewError("ARG", "Invalid block reference. Closing stream.")
+		session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, nil)
+		return false
+	}
+
+	if err != nil {
+		log.Debugf("Could not copy block: %v", err)
+		cancelWriteStream(s)
+		wireErr := wire.NewError("IO", "Could not write chunk to disk. Closing stream.")
+		session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, nil)
 		return false
 	}
 
@@ -399,21 +783,195 @@ func handleChunk(chunk []byte, tag string, s *writeStream, session *S, wd *watch
 }
 
 func cancelWriteStream(s *writeStream) {
+	if s.upload != nil {
+		drainFrames(s)
+		s.file.Close()
+		s.upload.suspend()
+		return
+	}
+
 	s.file.Close()
 	os.Remove(s.file.Name())
 }
 
-func finishWriteStream(s *writeStream, tag string, session *S) {
+// Keeps the chunks that were received before the stream got interrupted
+func drainFrames(s *writeStream) {
+	for {
+		select {
+		case frame := <-s.frames:
+			if frame.end || frame.payload == nil {
+				continue
+			}
+
+			err := s.write(frame.payload.Data)
+			frame.payload.Release()
+
+			if err != nil {
+				log.Debugf("Could not write file to disk: %v", err)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func finishWriteStream(s *writeStream, tag string, checksum string, session *S) {
 	tmpPath := s.file.Name()
 	s.file.Close()
 
+	if checksum != "" {
+		if wireErr := verifyChecksum(tmpPath, checksum); wireErr != nil {
+			if wireErr.Code == "CHECKSUM" {
+				discardWriteStream(s)
+			} else if s.upload != nil {
+				s.upload.suspend()
+			} else {
+				os.Remove(tmpPath)
+			}
+
+			session.send(s.out, wire.NewTaggedValue(wireErr, tag), 0, nil)
+			return
+		}
+	}
+
 	err := os.Rename(tmpPath, s.finalPath)
 
 	if err != nil {
 		log.Errorf("Could not write file to disk: %v", err)
+
+		if s.upload != nil {
+			s.upload.suspend()
+		} else {
+			os.Remove(tmpPath)
+		}
+
 		err := wire.NewError("IO", "Could not write file to disk.")
-		session.dataOut <- wire.NewTaggedValue(err, tag)
+		session.send(s.out, wire.NewTaggedValue(err, tag), 0, nil)
+		return
+	}
+
+	if s.upload != nil {
+		s.upload.complete()
+	}
+
+	info, err := os.Stat(s.finalPath)
+
+	if err != nil {
+		log.Errorf("Could not stat file after writing: %v", err)
+		err := wire.NewError("IO", "Could not read file from disk.")
+		session.send(s.out, wire.NewTaggedValue(err, tag), 0, nil)
+		return
+	}
+
+	var mtime wire.Value = wire.NewString(info.ModTime().UTC().Format(time.RFC3339Nano))
+
+	if session.HasExtension("TIMESTAMPS") {
+		mtime = wire.NewTimestamp(info.ModTime())
+	}
+
+	ack := wire.NewArray([]wire.Value{wire.NewInteger(info.Size()), mtime})
+
+	session.send(s.out, wire.NewTaggedValue(ack, tag), 0, nil)
+}
+
+func verifyChecksum(path string, checksum string) *wire.Error {
+	i := strings.Index(checksum, ":")
+
+	if i == -1 {
+		return wire.NewError("ARG", "Checksum should be formatted as ALGORITHM:digest")
+	}
+
+	algorithm, expected := checksum[:i], checksum[i+1:]
+
+	if !digest.Supported(algorithm) {
+		return wire.NewError("ARG", "Unsupported algorithm: %s", algorithm)
+	}
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		log.Debugf("Could not open file for hashing: %v", err)
+		return wire.NewError("IO", "Could not read file from disk.")
+	}
+
+	defer f.Close()
+
+	actual, err := digest.Sum(f, algorithm)
+
+	if err != nil {
+		log.Debugf("Could not hash file: %v", err)
+		return wire.NewError("IO", "Could not read file from disk.")
 	}
+
+	if !strings.EqualFold(actual, expected) {
+		return wire.NewError("CHECKSUM", "Checksum mismatch, the file was discarded")
+	}
+
+	return nil
+}
+
+// Throws away the data received so far, even for resumable uploads
+func discardWriteStream(s *writeStream) {
+	os.Remove(s.file.Name())
+
+	if s.upload != nil {
+		s.upload.complete()
+	}
+}
+
+var errCorrupt = errors.New("corrupt chunk")
+
+// Writes a chunk to disk, decompressing it first if needed
+func (s *writeStream) write(chunk []byte) error {
+	if s.decoder == nil {
+		_, err := s.file.Write(chunk)
+		return err
+	}
+
+	info, err := s.file.Stat()
+
+	if err != nil {
+		return err
+	}
+
+	// The chunk is decompressed straight to disk. If it turns out to be corrupt,
+	// whatever was written is cut off, so that resumable uploads don't keep it.
+	w := &fileWriter{file: s.file}
+
+	if _, err := s.decoder.Decode(w, chunk); err != nil {
+		if w.err != nil {
+			return w.err
+		}
+
+		if err := s.file.Truncate(info.Size()); err != nil {
+			return err
+		}
+
+		if _, err := s.file.Seek(info.Size(), io.SeekStart); err != nil {
+			return err
+		}
+
+		return fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+
+	return nil
+}
+
+// Tells disk errors apart from decompression errors
+type fileWriter struct {
+	file *os.File
+	err  error
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+
+	if err != nil {
+		w.err = err
+	}
+
+	return n, err
 }
 
 func cancelCopyStream(tmp *os.File) {
@@ -421,7 +979,7 @@ func cancelCopyStream(tmp *os.File) {
 	os.Remove(tmp.Name())
 }
 
-func newDataFrame(payload []byte) frame {
+func newDataFrame(payload *wire.Blob) frame {
 	return frame{end: false, payload: payload}
 }
 
@@ -429,6 +987,15 @@ func newFinishFrame() frame {
 	return frame{end: true}
 }
 
+func newBlockFrame(block int) frame {
+	return frame{end: false, block: block}
+}
+
+// The client expects the file to have the given digest, formatted as ALGORITHM:hex
+func newChecksumFrame(checksum string) frame {
+	return frame{end: true, checksum: checksum}
+}
+
 func (s *writeStream) mode() mode {
 	return write
 }
@@ -455,3 +1022,12 @@ func (s *copyStream) close() {
 	s.cancel <- struct{}{}
 	<-s.done
 }
+
+func (s *syncStream) mode() mode {
+	return diff
+}
+
+func (s *syncStream) close() {
+	s.cancel <- struct{}{}
+	<-s.done
+}
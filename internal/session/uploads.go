@@ -0,0 +1,177 @@
+package session
+
+import (
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ngagnon/flybywire/internal/crypto"
+	log "github.com/ngagnon/flybywire/internal/logging"
+	"github.com/ngagnon/flybywire/internal/vfs"
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+// Partial uploads that haven't been touched in that long are discarded
+const uploadExpiry = 24 * time.Hour
+
+// How often ExpireUploads looks for expired partial uploads
+const uploadExpiryInterval = time.Hour
+
+// A resumable upload outlives the stream (and the connection) that created it,
+// so that a client can pick up where it left off after a network failure.
+type upload struct {
+	token     string
+	username  string // only the user who started the upload can resume it
+	finalPath string
+	tmpPath   string
+	active    bool
+	updated   time.Time
+}
+
+var uploads = make(map[string]*upload)
+var uploadsLock sync.Mutex
+
+func beginUpload(finalPath string, token string, username string) (u *upload, file *os.File, wireErr *wire.Error) {
+	uploadsLock.Lock()
+	defer uploadsLock.Unlock()
+
+	expireUploads()
+
+	if token == "" {
+		return newUpload(finalPath, username)
+	}
+
+	u, ok := uploads[token]
+
+	if !ok || u.finalPath != finalPath || u.username != username {
+		return nil, nil, wire.NewError("NOTFOUND", "No such upload")
+	}
+
+	if u.active {
+		return nil, nil, wire.NewError("ILLEGAL", "Upload is already in progress")
+	}
+
+	file, err := os.OpenFile(u.tmpPath, os.O_WRONLY|os.O_APPEND, 0)
+
+	if err != nil {
+		log.Debugf("Could not reopen partial upload: %v", err)
+		delete(uploads, token)
+		return nil, nil, wire.NewError("NOTFOUND", "No such upload")
+	}
+
+	u.active = true
+	u.updated = time.Now()
+
+	return u, file, nil
+}
+
+func newUpload(finalPath string, username string) (u *upload, file *os.File, wireErr *wire.Error) {
+	key, err := crypto.RandomKey(16)
+
+	if err != nil {
+		log.Errorf("Could not generate upload token: %v", err)
+		return nil, nil, wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	file, err = createStagingFile(finalPath)
+
+	if err != nil {
+		log.Debugf("Could not create temporary file: %v", err)
+		return nil, nil, wire.NewError("ERR", "Unexpected error occurred")
+	}
+
+	u = &upload{
+		token:     hex.EncodeToString(key),
+		username:  username,
+		finalPath: finalPath,
+		tmpPath:   file.Name(),
+		active:    true,
+		updated:   time.Now(),
+	}
+
+	uploads[u.token] = u
+
+	return u, file, nil
+}
+
+// Called when the stream is interrupted: the partial file is kept around for later
+func (u *upload) suspend() {
+	uploadsLock.Lock()
+	defer uploadsLock.Unlock()
+
+	u.active = false
+	u.updated = time.Now()
+}
+
+func (u *upload) complete() {
+	uploadsLock.Lock()
+	defer uploadsLock.Unlock()
+
+	delete(uploads, u.token)
+}
+
+// Discards expired partial uploads every so often, so that they don't pile up
+// on disk when no new uploads are started
+func ExpireUploads() {
+	ticker := time.NewTicker(uploadExpiryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		uploadsLock.Lock()
+		expireUploads()
+		uploadsLock.Unlock()
+	}
+}
+
+// Must be called with uploadsLock held
+func expireUploads() {
+	for token, u := range uploads {
+		if !u.active && time.Since(u.updated) > uploadExpiry {
+			os.Remove(u.tmpPath)
+			delete(uploads, token)
+		}
+	}
+}
+
+// Files are staged in the destination folder (rather than in /tmp) so that
+// they can be renamed into place without crossing filesystems.
+func createStagingFile(finalPath string) (*os.File, error) {
+	dir, name := filepath.Split(finalPath)
+	return os.CreateTemp(dir, "."+name+".*"+vfs.UploadSuffix)
+}
+
+// Removes the staged uploads left behind by a previous run of the server, i.e.
+// the ones that were last modified before it started.
+func RemoveStaleUploads(rootDir string, started time.Time) {
+	flyDir := filepath.Join(rootDir, ".fly")
+
+	filepath.WalkDir(rootDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Debugf("Could not scan for stale uploads: %v", err)
+			return nil
+		}
+
+		if d.IsDir() && p == flyDir {
+			return filepath.SkipDir
+		}
+
+		if !d.Type().IsRegular() || !vfs.IsUpload(d.Name()) {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if err != nil || !info.ModTime().Before(started) {
+			return nil
+		}
+
+		if err := os.Remove(p); err != nil {
+			log.Debugf("Could not remove stale upload: %v", err)
+		}
+
+		return nil
+	})
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/ngagnon/flybywire/internal/wire"
 )
 
+// Maximum number of tagged commands running at the same time, per session
+const maxTaggedCommands = 16
+
 func runCommands(cb CommandHandler, s *S) {
 	s.waitGroup.Add(1)
 	defer s.waitGroup.Done()
@@ -23,7 +26,12 @@ func runCommands(cb CommandHandler, s *S) {
 		case arr := <-s.commands:
 			if arr.Values[0] == outMarker {
 				s.cmdOut <- arr.Values[1]
+			} else if arr.Values[0] == tagMarker {
+				if !runTaggedCommand(cb, arr.Values[1].(*wire.TaggedValue), s) {
+					return
+				}
 			} else if commandIsQuit(arr.Values[0]) {
+				s.tagged.Wait()
 				s.cmdOut <- wire.OK
 				close(s.cmdOut) // drain the pending writes
 				return
@@ -35,6 +43,41 @@ func runCommands(cb CommandHandler, s *S) {
 	}
 }
 
+// Runs the command in the background, the response is tagged with the request
+// ID so that the client can match it. Returns false if the session should end.
+func runTaggedCommand(cb CommandHandler, tagged *wire.TaggedValue, s *S) bool {
+	cmd := tagged.Value.(*wire.Array)
+
+	if commandIsQuit(cmd.Values[0]) {
+		s.tagged.Wait()
+		s.cmdOut <- wire.NewTaggedValue(wire.OK, tagged.Tag)
+		close(s.cmdOut) // drain the pending writes
+		return false
+	}
+
+	select {
+	case <-s.done:
+		return false
+	case s.taggedSlots <- struct{}{}:
+	}
+
+	s.tagged.Add(1)
+
+	go func() {
+		defer s.tagged.Done()
+		defer func() { <-s.taggedSlots }()
+
+		response := wire.NewTaggedValue(cb(cmd, s), tagged.Tag)
+
+		select {
+		case <-s.done:
+		case s.cmdOut <- response:
+		}
+	}()
+
+	return true
+}
+
 func commandIsQuit(val wire.Value) bool {
 	return strings.ToUpper(val.(*wire.String).Value) == "QUIT"
 }
@@ -5,6 +5,7 @@ import (
 	"io"
 
 	log "github.com/ngagnon/flybywire/internal/logging"
+	"github.com/ngagnon/flybywire/internal/wire"
 )
 
 var done = errors.New("done")
@@ -14,13 +15,21 @@ func handleWrites(conn io.Writer, s *S) {
 	s.waitGroup.Add(1)
 	defer s.waitGroup.Done()
 
+	w := wire.NewWriter(conn)
+
 	for {
-		err := handleWrite(conn, s)
+		err := handleWrite(w, s)
 
 		if err == done {
 			return
 		}
 
+		if err == drain {
+			if err = w.Flush(); err == nil {
+				err = drain
+			}
+		}
+
 		if err != nil {
 			if err != drain {
 				log.Debugf("Connection terminated due to write error: %v", err)
@@ -32,13 +41,18 @@ func handleWrites(conn io.Writer, s *S) {
 	}
 }
 
-func handleWrite(conn io.Writer, s *S) error {
+// Values are buffered, and only flushed once there's nothing left to write
+func handleWrite(w *wire.WireWriter, s *S) error {
 	select {
 	case <-s.done:
 		return done
 	default:
 	}
 
+	if s.response != nil {
+		return s.writePart(w)
+	}
+
 	select {
 	case val := <-s.cmdOut:
 		// cmdOut has been closed, which means we should drain cmdOut, then terminate
@@ -46,10 +60,22 @@ func handleWrite(conn io.Writer, s *S) error {
 			return drain
 		}
 
-		return val.WriteTo(conn)
+		return writeValue(w, val, s)
+	default:
+	}
+
+	select {
+	case <-s.wake:
+		return s.writeNext(w)
 	default:
 	}
 
+	if w.Buffered() > 0 {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
 	select {
 	case <-s.done:
 		return done
@@ -59,8 +85,23 @@ func handleWrite(conn io.Writer, s *S) error {
 			return drain
 		}
 
-		return val.WriteTo(conn)
-	case val := <-s.dataOut:
-		return val.WriteTo(conn)
+		return writeValue(w, val, s)
+	case <-s.wake:
+		return s.writeNext(w)
+	}
+}
+
+// Responses are written in parts, starting with the next call to handleWrite
+func writeValue(w *wire.WireWriter, val wire.Value, s *S) error {
+	err := w.Write(val)
+
+	if tagged, ok := val.(*wire.TaggedValue); ok {
+		val = tagged.Value
 	}
+
+	if r, ok := val.(*Response); ok {
+		s.response = r
+	}
+
+	return err
 }
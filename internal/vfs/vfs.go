@@ -16,6 +16,9 @@ type PolicyStore interface {
 	GetPolicies(path string, username string, action db.Action) []db.Policy
 }
 
+// Uploads are staged in hidden files next to their destination, with this suffix
+const UploadSuffix = ".fly-upload"
+
 var store PolicyStore
 var rootDir string
 
@@ -57,13 +60,18 @@ func resolve(vPath string, user *db.User, action *db.Action) (realPath string, e
 	realPath = path.Join(rootDir, cleanPath)
 	flyRoot := path.Join(rootDir, ".fly")
 
-	if strings.HasPrefix(realPath, flyRoot) {
+	if strings.HasPrefix(realPath, flyRoot) || IsUpload(path.Base(cleanPath)) {
 		return "", ErrReserved
 	}
 
 	return realPath, nil
 }
 
+// Whether the file name belongs to a staged upload
+func IsUpload(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, UploadSuffix)
+}
+
 func authorize(user *db.User, cleanPath string, action db.Action) bool {
 	if user == nil {
 		return false
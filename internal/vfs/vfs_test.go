@@ -250,3 +250,20 @@ func TestAdminUserExplicitDeny(t *testing.T) {
 		t.Fatalf("Resolve should have allowed the operation, got %v", err)
 	}
 }
+
+func TestResolveStagedUpload(t *testing.T) {
+	store := &policyStore{policies: make([]db.Policy, 0)}
+	setup(store, t)
+
+	_, err := ResolveSingleUser("/home/johnnyboy/.jambalaya.txt.4a7f3c.fly-upload")
+
+	if !errors.Is(err, ErrReserved) {
+		t.Fatalf("ResolveSingleUser should have returned ErrReserved, got %v", err)
+	}
+
+	_, err = ResolveSingleUser("/home/johnnyboy/jambalaya.fly-upload")
+
+	if err != nil {
+		t.Fatalf("ResolveSingleUser should have allowed the operation, got %v", err)
+	}
+}
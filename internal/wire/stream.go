@@ -0,0 +1,334 @@
+package wire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Arrays and tables can be streamed, i.e. sent before their length is known.
+// The length is replaced by a question mark, and the last element is followed
+// by an end marker (a period on its own line). The sender can abort the value
+// by sending an error in place of the next element (or row).
+const Streamed = -1
+
+var ErrIncomplete = errors.New("array or table is incomplete")
+
+// Writes arrays and tables one element at a time
+type Encoder struct {
+	w       io.Writer
+	open    []aggregate
+	written int64
+}
+
+type aggregate struct {
+	streamed  bool
+	remaining int
+}
+
+// Reads arrays and tables one element (or row) at a time
+type Decoder struct {
+	r      *WireReader
+	header *Header
+	read   int
+}
+
+type Header struct {
+	Tag   string // empty when the value isn't tagged
+	Table bool   // false for arrays
+	Len   int    // number of elements (or rows), Streamed when unknown
+	Cols  int
+}
+
+// A table whose rows are produced while it's being written, so that it never
+// has to be held in memory as a whole. Next returns a nil row once there are
+// no rows left.
+type TableStream struct {
+	Cols int
+	Next func() ([]Value, *Error)
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Starts an array of n elements, or a streamed array if n is Streamed. The
+// elements are then written with Encode, followed by a call to End.
+func (e *Encoder) BeginArray(n int) error {
+	if n < 0 {
+		return e.begin(aggregate{streamed: true}, "*?\n")
+	}
+
+	return e.begin(aggregate{remaining: n}, fmt.Sprintf("*%d\n", n))
+}
+
+// Starts a table, or a streamed table if rows is Streamed. The cells are then
+// written with Encode, row by row, followed by a call to End.
+func (e *Encoder) BeginTable(rows int, cols int) error {
+	if rows < 0 && cols <= 0 {
+		return errors.New("streamed tables should have at least one column")
+	}
+
+	if rows < 0 {
+		return e.begin(aggregate{streamed: true}, fmt.Sprintf("=?,%d\n", cols))
+	}
+
+	return e.begin(aggregate{remaining: rows * cols}, fmt.Sprintf("=%d,%d\n", rows, cols))
+}
+
+func (e *Encoder) begin(a aggregate, header string) error {
+	if err := e.writeString(header); err != nil {
+		return err
+	}
+
+	e.open = append(e.open, a)
+	return nil
+}
+
+func (e *Encoder) Encode(v Value) error {
+	n, err := v.WriteTo(e.w)
+	e.written += n
+
+	if err != nil {
+		return err
+	}
+
+	if len(e.open) > 0 {
+		e.open[len(e.open)-1].remaining--
+	}
+
+	return nil
+}
+
+// Finishes the array or table that was started last
+func (e *Encoder) End() error {
+	if len(e.open) == 0 {
+		return errors.New("no array or table to end")
+	}
+
+	a := e.open[len(e.open)-1]
+	e.open = e.open[:len(e.open)-1]
+
+	if a.streamed {
+		return e.writeString(".\n")
+	}
+
+	if a.remaining != 0 {
+		return ErrIncomplete
+	}
+
+	return nil
+}
+
+// Stops a streamed array or table, letting the receiver know why
+func (e *Encoder) Abort(wireErr *Error) error {
+	if len(e.open) == 0 || !e.open[len(e.open)-1].streamed {
+		return errors.New("only streamed arrays and tables can be aborted")
+	}
+
+	e.open = e.open[:len(e.open)-1]
+	n, err := wireErr.WriteTo(e.w)
+	e.written += n
+	return err
+}
+
+func (e *Encoder) writeString(s string) error {
+	n, err := io.WriteString(e.w, s)
+	e.written += int64(n)
+	return err
+}
+
+func NewDecoder(r *WireReader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Reads the start of the next value. For arrays and tables, only the header is
+// read, and the elements are then read with Next. Any other value is returned whole.
+func (d *Decoder) Begin() (*Header, Value, error) {
+	d.header = nil
+	d.read = 0
+	tag := ""
+
+	b, err := d.r.r.ReadByte()
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrIO, err)
+	}
+
+	if b == '@' {
+		line, err := readLine(d.r.r)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tag = string(line)
+
+		if b, err = d.r.r.ReadByte(); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrIO, err)
+		}
+	}
+
+	header := &Header{Tag: tag}
+
+	switch b {
+	case '*':
+		if header.Len, err = readLength(d.r.r); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrFormat, err)
+		}
+	case '=':
+		header.Table = true
+
+		if header.Len, header.Cols, err = readTableSize(d.r.r); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrFormat, err)
+		}
+	default:
+		d.r.r.UnreadByte()
+		val, err := readValue(d.r, false)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if tag != "" {
+			val = NewTaggedValue(val, tag)
+		}
+
+		return nil, val, nil
+	}
+
+	d.header = header
+	return header, nil, nil
+}
+
+// Returns the next row of the table (or element of the array, as a row of one),
+// and io.EOF once they have all been read. If the sender aborted, the *Error
+// it sent is returned.
+func (d *Decoder) Next() ([]Value, error) {
+	h := d.header
+
+	if h == nil {
+		return nil, io.EOF
+	}
+
+	if h.Len >= 0 && d.read == h.Len {
+		d.header = nil
+		return nil, io.EOF
+	}
+
+	cols := 1
+
+	if h.Table {
+		cols = h.Cols
+	}
+
+	row := make([]Value, cols)
+
+	for i := range row {
+		var val Value
+		var err error
+
+		if h.Len < 0 && i == 0 {
+			var end bool
+
+			if val, end, err = readElement(d.r); end {
+				d.header = nil
+				return nil, io.EOF
+			}
+		} else {
+			val, err = readValue(d.r, false)
+		}
+
+		if err != nil {
+			d.header = nil
+			return nil, err
+		}
+
+		if wireErr, ok := val.(*Error); ok && h.Len < 0 && i == 0 {
+			d.header = nil
+			return nil, wireErr
+		}
+
+		row[i] = val
+	}
+
+	d.read++
+	return row, nil
+}
+
+// Reads the next element of a streamed array or table, end is true once the
+// end marker was reached
+func readElement(r *WireReader) (val Value, end bool, err error) {
+	b, err := r.r.Peek(1)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrIO, err)
+	}
+
+	if b[0] != '.' {
+		val, err = readValue(r, false)
+		return val, false, err
+	}
+
+	line, err := readLine(r.r)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(line) != 1 {
+		return nil, false, fmt.Errorf("%w: unexpected symbol %c", ErrFormat, rune(line[1]))
+	}
+
+	return nil, true, nil
+}
+
+func (t *TableStream) WriteTo(w io.Writer) (int64, error) {
+	e := NewEncoder(w)
+
+	if err := e.BeginTable(Streamed, t.Cols); err != nil {
+		return e.written, err
+	}
+
+	for {
+		row, wireErr := t.Next()
+
+		if wireErr != nil {
+			return e.written, e.Abort(wireErr)
+		}
+
+		if row == nil {
+			return e.written, e.End()
+		}
+
+		for _, v := range row {
+			if err := e.Encode(v); err != nil {
+				return e.written, err
+			}
+		}
+	}
+}
+
+// Reads all the rows into a regular table, for peers that don't support
+// streamed values. Returns the error if Next fails.
+func (t *TableStream) Collect() Value {
+	table := &Table{ColCount: t.Cols}
+
+	for {
+		row, wireErr := t.Next()
+
+		if wireErr != nil {
+			return wireErr
+		}
+
+		if row == nil {
+			return table
+		}
+
+		table.Add(row)
+	}
+}
+
+func (t *TableStream) Name() string {
+	return "table"
+}
@@ -0,0 +1,215 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+)
+
+func TestStreamedTable(t *testing.T) {
+	buf := new(bytes.Buffer)
+	e := NewEncoder(buf)
+
+	if err := e.BeginTable(Streamed, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		e.Encode(NewString("file" + strconv.Itoa(i)))
+		e.Encode(NewInteger(int64(i)))
+	}
+
+	if err := e.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := NewReader(bytes.NewReader(buf.Bytes())).Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table, ok := value.(*Table)
+
+	if !ok {
+		t.Fatalf("Expected value to be a table, was %s", value.Name())
+	}
+
+	if table.RowCount != 3 || table.ColCount != 2 {
+		t.Fatalf("Expected a 3x2 table, got %dx%d", table.RowCount, table.ColCount)
+	}
+
+	d := NewDecoder(NewReader(buf))
+	header, _, err := d.Begin()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header == nil || !header.Table || header.Len != Streamed || header.Cols != 2 {
+		t.Fatalf("Unexpected header %+v", header)
+	}
+
+	for i := 0; ; i++ {
+		row, err := d.Next()
+
+		if err == io.EOF {
+			if i != 3 {
+				t.Fatalf("Expected 3 rows, got %d", i)
+			}
+
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if row[1].(*Integer).Value != int64(i) {
+			t.Fatalf("Expected row %d, got %d", i, row[1].(*Integer).Value)
+		}
+	}
+}
+
+func TestStreamedTableWithoutColumns(t *testing.T) {
+	_, err := NewReader(bytes.NewBufferString("=?,0\n.\n")).Read()
+
+	if !errors.Is(err, ErrFormat) {
+		t.Fatalf("Expected format error, got %v", err)
+	}
+
+	_, _, err = NewDecoder(NewReader(bytes.NewBufferString("=?,0\n.\n"))).Begin()
+
+	if !errors.Is(err, ErrFormat) {
+		t.Fatalf("Expected format error, got %v", err)
+	}
+
+	if err := NewEncoder(new(bytes.Buffer)).BeginTable(Streamed, 0); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestStreamedArrayAborted(t *testing.T) {
+	buf := new(bytes.Buffer)
+	e := NewEncoder(buf)
+	e.BeginArray(Streamed)
+	e.Encode(NewString("first"))
+
+	if err := e.Abort(NewError("IO", "Could not read folder")); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := NewReader(bytes.NewReader(buf.Bytes())).Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wireErr, ok := value.(*Error); !ok || wireErr.Code != "IO" {
+		t.Fatalf("Expected IO error, got %v", value)
+	}
+
+	d := NewDecoder(NewReader(buf))
+
+	if _, _, err := d.Begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.Next()
+	var wireErr *Error
+
+	if !errors.As(err, &wireErr) || wireErr.Code != "IO" {
+		t.Fatalf("Expected IO error, got %v", err)
+	}
+}
+
+func TestSizedArrayIncomplete(t *testing.T) {
+	e := NewEncoder(new(bytes.Buffer))
+	e.BeginArray(2)
+	e.Encode(NewString("first"))
+
+	if err := e.End(); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("Expected ErrIncomplete, got %v", err)
+	}
+}
+
+func TestDecoderTaggedTable(t *testing.T) {
+	buf := new(bytes.Buffer)
+	table := &Table{}
+	table.Add([]Value{NewString("a"), NewString("b")})
+
+	if _, err := NewTaggedValue(table, "req1").WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewString("PONG").WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDecoder(NewReader(buf))
+	header, _, err := d.Begin()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Tag != "req1" || header.Len != 1 {
+		t.Fatalf("Unexpected header %+v", header)
+	}
+
+	if row, err := d.Next(); err != nil || len(row) != 2 {
+		t.Fatalf("Expected a row of 2, got %v (%v)", row, err)
+	}
+
+	if _, err := d.Next(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+
+	header, value, err := d.Begin()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header != nil || value.(*String).Value != "PONG" {
+		t.Fatalf("Expected a whole string, got %v", value)
+	}
+}
+
+func TestTableStream(t *testing.T) {
+	i := 0
+	stream := &TableStream{
+		Cols: 1,
+		Next: func() ([]Value, *Error) {
+			if i == 2 {
+				return nil, nil
+			}
+
+			i++
+			return []Value{NewInteger(int64(i))}, nil
+		},
+	}
+
+	buf := new(bytes.Buffer)
+
+	if _, err := stream.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "=?,1\n:1\n:2\n.\n" {
+		t.Fatalf("Unexpected encoding %q", buf.String())
+	}
+
+	i = 0
+	table, ok := stream.Collect().(*Table)
+
+	if !ok || table.RowCount != 2 {
+		t.Fatalf("Expected a table of 2 rows, got %v", table)
+	}
+}
@@ -7,12 +7,15 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Value interface {
-	WriteTo(io.Writer) error
+	io.WriterTo
 	Name() string
 }
 
@@ -43,10 +46,22 @@ type String struct {
 
 type Blob struct {
 	Data []byte
+
+	buf  *[]byte
+	pool *BlobPool
 }
 
+// Integers are signed and 64 bits wide, larger values are rejected by the reader
 type Integer struct {
-	Value int
+	Value int64
+}
+
+type Float struct {
+	Value float64
+}
+
+type Timestamp struct {
+	Value time.Time
 }
 
 type Error struct {
@@ -66,9 +81,23 @@ var ErrIO = errors.New("I/O error")
 type WireReader struct {
 	MaxBlobSize int
 
+	// When set, blobs that fit are read into buffers taken from the pool
+	Pool *BlobPool
+
 	r *bufio.Reader
 }
 
+type WireWriter struct {
+	w *bufio.Writer
+}
+
+// Recycles blob buffers, so that reading a blob doesn't allocate a new one.
+// Blobs read into a pooled buffer should be released once they're no longer used.
+type BlobPool struct {
+	size int
+	pool sync.Pool
+}
+
 func NewReader(r io.Reader) *WireReader {
 	bufReader, ok := r.(*bufio.Reader)
 
@@ -82,6 +111,42 @@ func NewReader(r io.Reader) *WireReader {
 	}
 }
 
+func NewWriter(w io.Writer) *WireWriter {
+	bufWriter, ok := w.(*bufio.Writer)
+
+	if !ok {
+		bufWriter = bufio.NewWriter(w)
+	}
+
+	return &WireWriter{w: bufWriter}
+}
+
+// Buffers the value, call Flush to send it
+func (w *WireWriter) Write(v Value) error {
+	_, err := v.WriteTo(w.w)
+	return err
+}
+
+func (w *WireWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// Returns the number of bytes waiting to be flushed
+func (w *WireWriter) Buffered() int {
+	return w.w.Buffered()
+}
+
+func NewBlobPool(size int) *BlobPool {
+	p := &BlobPool{size: size}
+
+	p.pool.New = func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}
+
+	return p
+}
+
 func (r *WireReader) Read() (Value, error) {
 	return readValue(r, true)
 }
@@ -112,10 +177,24 @@ func readValue(r *WireReader, canBeTag bool) (Value, error) {
 	case '=':
 		return handleTable(r)
 	case '*':
+		size, err := readLength(r.r)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrFormat, err)
+		}
+
+		return handleArray(r, size)
+	case ':':
+		return handleInteger(r.r)
+	case ',':
+		return handleFloat(r.r)
+	case '^':
+		return handleTimestamp(r.r)
+	case '%':
 		fallthrough
 	case '$':
 		fallthrough
-	case ':':
+	case '~':
 		size, err := readSize(r.r)
 
 		if err != nil {
@@ -123,12 +202,12 @@ func readValue(r *WireReader, canBeTag bool) (Value, error) {
 		}
 
 		switch b {
-		case '*':
-			return handleArray(r, size)
+		case '%':
+			return handleMap(r, size)
 		case '$':
 			return handleBlob(r, size)
-		case ':':
-			return &Integer{Value: size}, nil
+		case '~':
+			return handleLongString(r, size)
 		}
 	}
 
@@ -161,11 +240,26 @@ func handleTable(r *WireReader) (Value, error) {
 		ColCount: cols,
 	}
 
-	for i := 0; i < rows; i++ {
+	for i := 0; rows == Streamed || i < rows; i++ {
 		row := make([]Value, cols)
 
 		for j, _ := range row {
-			val, err := readValue(r, false)
+			var val Value
+
+			if rows == Streamed && j == 0 {
+				var end bool
+
+				if val, end, err = readElement(r); end {
+					return t, nil
+				}
+
+				// The sender gave up half-way through
+				if wireErr, ok := val.(*Error); ok {
+					return wireErr, nil
+				}
+			} else {
+				val, err = readValue(r, false)
+			}
 
 			if err != nil {
 				return nil, err
@@ -193,15 +287,20 @@ func readTableSize(r *bufio.Reader) (rows int, cols int, err error) {
 		return 0, 0, fmt.Errorf("invalid table size: %s", string(line))
 	}
 
-	if rows, err = strconv.Atoi(string(line[0:i])); err != nil {
+	if rows, err = parseLength(line[0:i]); err != nil {
 		return 0, 0, fmt.Errorf("invalid table size: %s", string(line))
 	}
 
-	if cols, err = strconv.Atoi(string(line[i+1:])); err != nil {
+	if cols, err = strconv.Atoi(string(line[i+1:])); err != nil || cols < 0 {
 		return 0, 0, fmt.Errorf("invalid table size: %s", string(line))
 	}
 
-	return
+	// The end marker takes the place of the first column, without columns it could never be read
+	if rows == Streamed && cols == 0 {
+		return 0, 0, fmt.Errorf("streamed tables should have at least one column: %s", string(line))
+	}
+
+	return rows, cols, nil
 }
 
 func handleBoolean(r *bufio.Reader) (Value, error) {
@@ -240,6 +339,62 @@ func handleString(r *bufio.Reader) (Value, error) {
 	return NewString(string(buf)), nil
 }
 
+func handleInteger(r *bufio.Reader) (Value, error) {
+	line, err := nextLine(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.ParseInt(string(line), 10, 64)
+
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("%w: integer out of range: %s", ErrFormat, string(line))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid integer: %s", ErrFormat, string(line))
+	}
+
+	return &Integer{Value: n}, nil
+}
+
+func handleFloat(r *bufio.Reader) (Value, error) {
+	line, err := nextLine(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := strconv.ParseFloat(string(line), 64)
+
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("%w: float out of range: %s", ErrFormat, string(line))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid float: %s", ErrFormat, string(line))
+	}
+
+	return &Float{Value: f}, nil
+}
+
+func handleTimestamp(r *bufio.Reader) (Value, error) {
+	line, err := nextLine(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, string(line))
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid timestamp: %s", ErrFormat, string(line))
+	}
+
+	return &Timestamp{Value: t.UTC()}, nil
+}
+
 func handleError(r *bufio.Reader) (Value, error) {
 	buf, err := r.ReadBytes('\n')
 
@@ -278,7 +433,11 @@ func handleTag(r *WireReader, canBeTag bool) (Value, error) {
 	return NewTaggedValue(val, string(buf)), nil
 }
 
-func handleArray(r *WireReader, len int) (*Array, error) {
+func handleArray(r *WireReader, len int) (Value, error) {
+	if len == Streamed {
+		return handleStreamedArray(r)
+	}
+
 	arr := &Array{
 		Values: make([]Value, len),
 	}
@@ -296,29 +455,140 @@ func handleArray(r *WireReader, len int) (*Array, error) {
 	return arr, nil
 }
 
+func handleStreamedArray(r *WireReader) (Value, error) {
+	arr := &Array{}
+
+	for {
+		val, end, err := readElement(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if end {
+			return arr, nil
+		}
+
+		// The sender gave up half-way through
+		if wireErr, ok := val.(*Error); ok {
+			return wireErr, nil
+		}
+
+		arr.Values = append(arr.Values, val)
+	}
+}
+
+// Keys can be strings or blobs, and must be unique
+func handleMap(r *WireReader, len int) (*Map, error) {
+	if len < 0 {
+		return nil, fmt.Errorf("%w: invalid size %d", ErrFormat, len)
+	}
+
+	m := &Map{
+		m: make(map[string]Value),
+	}
+
+	for i := 0; i < len; i++ {
+		key, err := readValue(r, false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var k string
+
+		switch kv := key.(type) {
+		case *String:
+			k = kv.Value
+		case *Blob:
+			k = string(kv.Data)
+		default:
+			return nil, fmt.Errorf("%w: map keys should be strings, got %s", ErrFormat, key.Name())
+		}
+
+		if _, ok := m.m[k]; ok {
+			return nil, fmt.Errorf("%w: duplicate map key %s", ErrFormat, k)
+		}
+
+		val, err := readValue(r, false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		m.m[k] = val
+	}
+
+	return m, nil
+}
+
 func handleBlob(r *WireReader, size int) (*Blob, error) {
 	if size > r.MaxBlobSize {
 		return nil, fmt.Errorf("%w: blobs cannot exceed %d in length", ErrFormat, r.MaxBlobSize)
 	}
 
+	if size < 0 {
+		return nil, fmt.Errorf("%w: invalid size %d", ErrFormat, size)
+	}
+
+	if r.Pool != nil && size <= r.Pool.size {
+		buf := r.Pool.pool.Get().(*[]byte)
+
+		if err := readPayload(r, (*buf)[:size]); err != nil {
+			r.Pool.pool.Put(buf)
+			return nil, err
+		}
+
+		return &Blob{Data: (*buf)[:size], buf: buf, pool: r.Pool}, nil
+	}
+
+	buf := make([]byte, size)
+
+	if err := readPayload(r, buf); err != nil {
+		return nil, err
+	}
+
+	return &Blob{Data: buf}, nil
+}
+
+// Strings that can't be sent on a single line are length-prefixed, like blobs
+func handleLongString(r *WireReader, size int) (*String, error) {
+	if size > r.MaxBlobSize {
+		return nil, fmt.Errorf("%w: strings cannot exceed %d in length", ErrFormat, r.MaxBlobSize)
+	}
+
+	if size < 0 {
+		return nil, fmt.Errorf("%w: invalid size %d", ErrFormat, size)
+	}
+
 	buf := make([]byte, size)
+
+	if err := readPayload(r, buf); err != nil {
+		return nil, err
+	}
+
+	return NewString(string(buf)), nil
+}
+
+// Fills buf, then expects a line feed
+func readPayload(r *WireReader, buf []byte) error {
 	_, err := io.ReadFull(r.r, buf)
 
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrIO, err)
+		return fmt.Errorf("%w: %v", ErrIO, err)
 	}
 
 	b, err := r.r.ReadByte()
 
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrIO, err)
+		return fmt.Errorf("%w: %v", ErrIO, err)
 	}
 
 	if b != '\n' {
-		return nil, fmt.Errorf("%w: unexpected symbol %c, was expected new line", ErrFormat, rune(b))
+		return fmt.Errorf("%w: unexpected symbol %c, was expected new line", ErrFormat, rune(b))
 	}
 
-	return &Blob{Data: buf}, nil
+	return nil
 }
 
 func readSize(r *bufio.Reader) (count int, err error) {
@@ -337,6 +607,37 @@ func readSize(r *bufio.Reader) (count int, err error) {
 	return n, nil
 }
 
+// Reads the length of an array or table, which is Streamed when unknown
+func readLength(r *bufio.Reader) (int, error) {
+	line, err := nextLine(r)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := parseLength(line)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", string(line))
+	}
+
+	return n, nil
+}
+
+func parseLength(s []byte) (int, error) {
+	if string(s) == "?" {
+		return Streamed, nil
+	}
+
+	n, err := strconv.Atoi(string(s))
+
+	if err == nil && n < 0 {
+		err = errors.New("negative length")
+	}
+
+	return n, err
+}
+
 func nextLine(r *bufio.Reader) ([]byte, error) {
 	for {
 		line, err := readLine(r)
@@ -375,15 +676,27 @@ func NewBlob(data []byte) *Blob {
 	return &Blob{Data: data}
 }
 
-func NewInteger(val int) *Integer {
+func NewInteger(val int64) *Integer {
 	return &Integer{Value: val}
 }
 
+func NewFloat(val float64) *Float {
+	return &Float{Value: val}
+}
+
+func NewTimestamp(val time.Time) *Timestamp {
+	return &Timestamp{Value: val}
+}
+
 func NewBoolean(val bool) *Bool {
 	return &Bool{Value: val}
 }
 
 func NewMap(m map[string]Value) *Map {
+	if m == nil {
+		m = make(map[string]Value)
+	}
+
 	return &Map{m: m}
 }
 
@@ -395,86 +708,209 @@ func NewTaggedValue(val Value, tag string) *TaggedValue {
 	return &TaggedValue{Tag: tag, Value: val}
 }
 
-func (b *Bool) WriteTo(w io.Writer) error {
+func (b *Bool) WriteTo(w io.Writer) (int64, error) {
 	out := "#f\n"
 
 	if b.Value {
 		out = "#t\n"
 	}
 
-	_, err := fmt.Fprint(w, out)
-	return err
+	n, err := io.WriteString(w, out)
+	return int64(n), err
 }
 
-func (s *String) WriteTo(w io.Writer) (err error) {
-	_, err = fmt.Fprintf(w, "+%s\n", s.Value)
-	return
-}
+func (s *String) WriteTo(w io.Writer) (int64, error) {
+	if strings.Contains(s.Value, "\n") {
+		return writePayload(w, '~', s.Value)
+	}
 
-func (s *Blob) WriteTo(w io.Writer) (err error) {
-	_, err = fmt.Fprintf(w, "$%d\n%s\n", len(s.Data), s.Data)
-	return
+	return writeLine(w, '+', s.Value)
 }
 
-func (i *Integer) WriteTo(w io.Writer) (err error) {
-	_, err = fmt.Fprintf(w, ":%d\n", i.Value)
-	return
+// Gives the blob's buffer back to the pool it came from. The blob's data
+// must not be used afterwards.
+func (s *Blob) Release() {
+	if s.pool == nil {
+		return
+	}
+
+	s.pool.pool.Put(s.buf)
+	s.Data = nil
+	s.buf = nil
+	s.pool = nil
 }
 
-func (f *TaggedValue) WriteTo(w io.Writer) (err error) {
-	buf := new(bytes.Buffer)
-	fmt.Fprintf(buf, "@%s\n", f.Tag)
-	f.Value.WriteTo(buf)
-	_, err = buf.WriteTo(w)
+func (s *Blob) WriteTo(w io.Writer) (n int64, err error) {
+	if n, err = writeSize(w, '$', len(s.Data)); err != nil {
+		return
+	}
+
+	// Data that doesn't fit in the buffer is written straight through, rather than copied
+	if bw, ok := w.(*bufio.Writer); ok && len(s.Data) > bw.Available() {
+		if err = bw.Flush(); err != nil {
+			return
+		}
+	}
+
+	m, err := w.Write(s.Data)
+	n += int64(m)
+
+	if err != nil {
+		return
+	}
+
+	m, err = w.Write(newline)
+	n += int64(m)
 	return
 }
 
-func (e *Error) WriteTo(w io.Writer) (err error) {
-	_, err = fmt.Fprintf(w, "-%s %s\n", e.Code, e.Message)
-	return
+func (i *Integer) WriteTo(w io.Writer) (int64, error) {
+	return writeInt(w, ':', i.Value)
 }
 
-func (n *null) WriteTo(w io.Writer) (err error) {
-	_, err = fmt.Fprint(w, "_\n")
-	return
+func (f *Float) WriteTo(w io.Writer) (int64, error) {
+	var buf [32]byte
+	b := strconv.AppendFloat(append(buf[:0], ','), f.Value, 'g', -1, 64)
+	n, err := w.Write(append(b, '\n'))
+	return int64(n), err
 }
 
-func (a *Array) WriteTo(w io.Writer) error {
-	buf := new(bytes.Buffer)
-	fmt.Fprintf(buf, "*%d\n", len(a.Values))
+// Timestamps are always sent in UTC, with nanosecond precision
+func (t *Timestamp) WriteTo(w io.Writer) (int64, error) {
+	var buf [40]byte
+	b := t.Value.UTC().AppendFormat(append(buf[:0], '^'), time.RFC3339Nano)
+	n, err := w.Write(append(b, '\n'))
+	return int64(n), err
+}
 
-	for _, v := range a.Values {
-		v.WriteTo(buf)
+func (f *TaggedValue) WriteTo(w io.Writer) (n int64, err error) {
+	if n, err = writeLine(w, '@', f.Tag); err != nil {
+		return
 	}
 
-	_, err := buf.WriteTo(w)
-	return err
+	m, err := f.Value.WriteTo(w)
+	return n + m, err
+}
+
+// Line feeds are replaced with spaces, since errors can only span one line
+func (e *Error) WriteTo(w io.Writer) (int64, error) {
+	msg := strings.ReplaceAll(e.Message, "\n", " ")
+	return writeLine(w, '-', e.Code+" "+msg)
+}
+
+func (n *null) WriteTo(w io.Writer) (int64, error) {
+	m, err := io.WriteString(w, "_\n")
+	return int64(m), err
 }
 
-func (t *Table) WriteTo(w io.Writer) error {
-	buf := new(bytes.Buffer)
-	fmt.Fprintf(buf, "=%d,%d\n", t.RowCount, t.ColCount)
+func (a *Array) WriteTo(w io.Writer) (n int64, err error) {
+	if n, err = writeSize(w, '*', len(a.Values)); err != nil {
+		return
+	}
 
-	for _, v := range t.Data {
-		v.WriteTo(buf)
+	m, err := writeValues(w, a.Values)
+	return n + m, err
+}
+
+func (t *Table) WriteTo(w io.Writer) (n int64, err error) {
+	if n, err = writeLine(w, '=', fmt.Sprintf("%d,%d", t.RowCount, t.ColCount)); err != nil {
+		return
 	}
 
-	_, err := buf.WriteTo(w)
-	return err
+	m, err := writeValues(w, t.Data)
+	return n + m, err
 }
 
-func (m *Map) WriteTo(w io.Writer) error {
-	buf := new(bytes.Buffer)
-	fmt.Fprintf(buf, "%%%d\n", len(m.m))
+func (m *Map) WriteTo(w io.Writer) (n int64, err error) {
+	if n, err = writeSize(w, '%', len(m.m)); err != nil {
+		return
+	}
 
-	for k, v := range m.m {
+	// Keys are sorted so that the same map is always written the same way
+	for _, k := range m.Keys() {
 		ks := String{Value: k}
-		ks.WriteTo(buf)
-		v.WriteTo(buf)
+		c, err := writeValues(w, []Value{&ks, m.m[k]})
+		n += c
+
+		if err != nil {
+			return n, err
+		}
 	}
 
-	_, err := buf.WriteTo(w)
-	return err
+	return n, nil
+}
+
+var newline = []byte{'\n'}
+
+// Writes a type symbol followed by a size (or an integer) and a line feed, e.g. $42
+func writeSize(w io.Writer, symbol byte, size int) (int64, error) {
+	return writeInt(w, symbol, int64(size))
+}
+
+func writeInt(w io.Writer, symbol byte, val int64) (int64, error) {
+	var buf [24]byte
+	b := strconv.AppendInt(append(buf[:0], symbol), val, 10)
+	n, err := w.Write(append(b, '\n'))
+	return int64(n), err
+}
+
+func writeLine(w io.Writer, symbol byte, line string) (n int64, err error) {
+	if n, err = writeByte(w, symbol); err != nil {
+		return
+	}
+
+	m, err := io.WriteString(w, line)
+	n += int64(m)
+
+	if err != nil {
+		return
+	}
+
+	c, err := writeByte(w, '\n')
+	return n + c, err
+}
+
+// Writes a length-prefixed payload, in the same format as blobs
+func writePayload(w io.Writer, symbol byte, payload string) (n int64, err error) {
+	if n, err = writeSize(w, symbol, len(payload)); err != nil {
+		return
+	}
+
+	m, err := io.WriteString(w, payload)
+	n += int64(m)
+
+	if err != nil {
+		return
+	}
+
+	c, err := writeByte(w, '\n')
+	return n + c, err
+}
+
+func writeByte(w io.Writer, b byte) (int64, error) {
+	if bw, ok := w.(io.ByteWriter); ok {
+		if err := bw.WriteByte(b); err != nil {
+			return 0, err
+		}
+
+		return 1, nil
+	}
+
+	n, err := w.Write([]byte{b})
+	return int64(n), err
+}
+
+func writeValues(w io.Writer, values []Value) (n int64, err error) {
+	for _, v := range values {
+		m, err := v.WriteTo(w)
+		n += m
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
 }
 
 func (h *TaggedValue) Name() string {
@@ -505,10 +941,23 @@ func (i *Integer) Name() string {
 	return "integer"
 }
 
+func (f *Float) Name() string {
+	return "float"
+}
+
+func (t *Timestamp) Name() string {
+	return "timestamp"
+}
+
 func (e *Error) Name() string {
 	return "error"
 }
 
+// Lets wire errors be returned as Go errors
+func (e *Error) Error() string {
+	return e.Code + " " + e.Message
+}
+
 func (m *Map) Name() string {
 	return "map"
 }
@@ -527,6 +976,31 @@ func (t *Table) Add(row []Value) {
 	t.RowCount++
 }
 
+func (m *Map) Get(key string) (val Value, ok bool) {
+	val, ok = m.m[key]
+	return
+}
+
+func (m *Map) Set(key string, val Value) {
+	m.m[key] = val
+}
+
+func (m *Map) Len() int {
+	return len(m.m)
+}
+
+// Returns the keys in sorted order
+func (m *Map) Keys() []string {
+	keys := make([]string, 0, len(m.m))
+
+	for k := range m.m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
 func (t *Table) Row(id int) []Value {
 	if id < 0 || id >= t.RowCount {
 		return nil
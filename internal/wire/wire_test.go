@@ -3,7 +3,9 @@ package wire
 import (
 	"bytes"
 	"errors"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestIOError(t *testing.T) {
@@ -30,7 +32,7 @@ func TestCommandFrame(t *testing.T) {
 		cmd = append(cmd, NewString(v))
 	}
 
-	if err := NewArray(cmd).WriteTo(buf); err != nil {
+	if _, err := NewArray(cmd).WriteTo(buf); err != nil {
 		t.Fatal(err)
 	}
 
@@ -69,7 +71,7 @@ func TestTaggedBlob(t *testing.T) {
 	payload := []byte("Hello, world!")
 	blob := NewBlob(payload)
 
-	if err := NewTaggedValue(blob, "1").WriteTo(buf); err != nil {
+	if _, err := NewTaggedValue(blob, "1").WriteTo(buf); err != nil {
 		t.Fatal(err)
 	}
 
@@ -113,7 +115,7 @@ func TestLargeBlob(t *testing.T) {
 
 	blob := NewBlob(payload)
 
-	if err := NewTaggedValue(blob, "1").WriteTo(buf); err != nil {
+	if _, err := NewTaggedValue(blob, "1").WriteTo(buf); err != nil {
 		t.Fatal(err)
 	}
 
@@ -141,7 +143,7 @@ func TestLargeBlob(t *testing.T) {
 	payload = append(payload, 'x')
 	blob = NewBlob(payload)
 
-	if err := NewTaggedValue(blob, "1").WriteTo(buf); err != nil {
+	if _, err := NewTaggedValue(blob, "1").WriteTo(buf); err != nil {
 		t.Fatal(err)
 	}
 
@@ -158,7 +160,7 @@ func TestLargeBlob(t *testing.T) {
 func TestTaggedNull(t *testing.T) {
 	buf := new(bytes.Buffer)
 
-	if err := NewTaggedValue(Null, "5").WriteTo(buf); err != nil {
+	if _, err := NewTaggedValue(Null, "5").WriteTo(buf); err != nil {
 		t.Fatal(err)
 	}
 
@@ -183,3 +185,328 @@ func TestTaggedNull(t *testing.T) {
 		t.Fatalf("Expected payload to be null, was %s", tagged.Value.Name())
 	}
 }
+
+func TestMap(t *testing.T) {
+	buf := new(bytes.Buffer)
+	m := NewMap(map[string]Value{
+		"username": NewString("bob"),
+		"admin":    NewBoolean(true),
+		"chroot":   Null,
+	})
+
+	if _, err := m.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "%3\n+admin\n#t\n+chroot\n_\n+username\n+bob\n"
+
+	if buf.String() != expected {
+		t.Fatalf("Expected keys to be sorted, got %q", buf.String())
+	}
+
+	reader := NewReader(buf)
+	value, err := reader.Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok := value.(*Map)
+
+	if !ok {
+		t.Fatalf("Expected value to be a map, was %s", value.Name())
+	}
+
+	if m.Len() != 3 {
+		t.Fatalf("Expected map to have 3 entries, had %d", m.Len())
+	}
+
+	username, ok := m.Get("username")
+
+	if !ok {
+		t.Fatalf("Expected map to have a username")
+	}
+
+	if str, ok := username.(*String); !ok || str.Value != "bob" {
+		t.Fatalf("Expected username to be 'bob', was %v", username)
+	}
+
+	if _, ok := m.Get("password"); ok {
+		t.Fatalf("Expected map not to have a password")
+	}
+
+	keys := m.Keys()
+
+	if len(keys) != 3 || keys[0] != "admin" || keys[1] != "chroot" || keys[2] != "username" {
+		t.Fatalf("Expected sorted keys, got %v", keys)
+	}
+}
+
+func TestMapInvalidKey(t *testing.T) {
+	buf := bytes.NewBufferString("%1\n:1\n+one\n")
+	_, err := NewReader(buf).Read()
+
+	if !errors.Is(err, ErrFormat) {
+		t.Fatalf("Expected format error, got %v", err)
+	}
+}
+
+func TestMapDuplicateKey(t *testing.T) {
+	buf := bytes.NewBufferString("%2\n+key\n:1\n$3\nkey\n:2\n")
+	_, err := NewReader(buf).Read()
+
+	if !errors.Is(err, ErrFormat) {
+		t.Fatalf("Expected format error, got %v", err)
+	}
+}
+
+func TestMapNegativeSize(t *testing.T) {
+	buf := bytes.NewBufferString("%-5\n")
+	_, err := NewReader(buf).Read()
+
+	if !errors.Is(err, ErrFormat) {
+		t.Fatalf("Expected format error, got %v", err)
+	}
+}
+
+func TestMultilineString(t *testing.T) {
+	buf := new(bytes.Buffer)
+	name := "some\nfile.txt"
+
+	if _, err := NewString(name).WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "~13\nsome\nfile.txt\n"
+
+	if buf.String() != expected {
+		t.Fatalf("Expected string to be length-prefixed, got %q", buf.String())
+	}
+
+	value, err := NewReader(buf).Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	str, ok := value.(*String)
+
+	if !ok {
+		t.Fatalf("Expected value to be a string, was %s", value.Name())
+	}
+
+	if str.Value != name {
+		t.Fatalf("Expected string to be %q, was %q", name, str.Value)
+	}
+}
+
+func TestLongStringSize(t *testing.T) {
+	reader := NewReader(bytes.NewBufferString("~6\nhello\n\n"))
+	reader.MaxBlobSize = 5
+	_, err := reader.Read()
+
+	if !errors.Is(err, ErrFormat) {
+		t.Fatalf("Expected format error, got %v", err)
+	}
+}
+
+func TestErrorNewline(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	if _, err := NewError("ARG", "Unsupported option: %s", "a\nb").WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "-ARG Unsupported option: a b\n" {
+		t.Fatalf("Expected error to fit on one line, got %q", buf.String())
+	}
+}
+
+func TestInteger64(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	if _, err := NewInteger(math.MinInt64).WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := NewReader(buf).Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i, ok := value.(*Integer); !ok || i.Value != math.MinInt64 {
+		t.Fatalf("Expected %d, got %v", int64(math.MinInt64), value)
+	}
+}
+
+func TestIntegerOverflow(t *testing.T) {
+	buf := bytes.NewBufferString(":9223372036854775808\n")
+	_, err := NewReader(buf).Read()
+
+	if !errors.Is(err, ErrFormat) {
+		t.Fatalf("Expected format error, got %v", err)
+	}
+}
+
+func TestFloat(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	if _, err := NewFloat(-1.5e-7).WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != ",-1.5e-07\n" {
+		t.Fatalf("Unexpected encoding %q", buf.String())
+	}
+
+	value, err := NewReader(buf).Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f, ok := value.(*Float); !ok || f.Value != -1.5e-7 {
+		t.Fatalf("Expected -1.5e-7, got %v", value)
+	}
+
+	if _, err := NewReader(bytes.NewBufferString(",1e400\n")).Read(); !errors.Is(err, ErrFormat) {
+		t.Fatalf("Expected format error, got %v", err)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zone := time.FixedZone("EDT", -4*60*60)
+	ts := time.Date(2021, 6, 14, 20, 8, 20, 232167574, zone)
+
+	if _, err := NewTimestamp(ts).WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != "^2021-06-15T00:08:20.232167574Z\n" {
+		t.Fatalf("Expected timestamp in UTC, got %q", buf.String())
+	}
+
+	value, err := NewReader(buf).Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := value.(*Timestamp); !ok || !v.Value.Equal(ts) {
+		t.Fatalf("Expected %v, got %v", ts, value)
+	}
+
+	if _, err := NewReader(bytes.NewBufferString("^yesterday\n")).Read(); !errors.Is(err, ErrFormat) {
+		t.Fatalf("Expected format error, got %v", err)
+	}
+}
+
+func TestBlobPool(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+
+	for _, payload := range []string{"first", "second"} {
+		if err := w.Write(NewTaggedValue(NewBlob([]byte(payload)), "1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewReader(buf)
+	reader.Pool = NewBlobPool(16)
+
+	for _, expected := range []string{"first", "second"} {
+		value, err := reader.Read()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blob, ok := value.(*TaggedValue).Value.(*Blob)
+
+		if !ok {
+			t.Fatalf("Expected payload to be a blob")
+		}
+
+		if string(blob.Data) != expected {
+			t.Fatalf("Expected payload to be '%s', was '%s'", expected, blob.Data)
+		}
+
+		blob.Release()
+
+		if blob.Data != nil {
+			t.Fatalf("Expected released blob to have no data")
+		}
+	}
+}
+
+func TestBlobLargerThanPool(t *testing.T) {
+	buf := new(bytes.Buffer)
+	payload := bytes.Repeat([]byte("x"), 32)
+
+	if _, err := NewBlob(payload).WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewReader(buf)
+	reader.Pool = NewBlobPool(16)
+	value, err := reader.Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob := value.(*Blob)
+
+	if !bytes.Equal(blob.Data, payload) {
+		t.Fatalf("Expected payload to be '%s', was '%s'", payload, blob.Data)
+	}
+
+	// Not pooled, so the data stays valid
+	blob.Release()
+
+	if !bytes.Equal(blob.Data, payload) {
+		t.Fatalf("Expected unpooled blob to keep its data")
+	}
+}
+
+func TestWriterLargeBlob(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	payload := bytes.Repeat([]byte("x"), 64*1024)
+
+	if err := w.Write(NewString("before")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Write(NewTaggedValue(NewBlob(payload), "3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewReader(buf)
+
+	if value, err := reader.Read(); err != nil || value.(*String).Value != "before" {
+		t.Fatalf("Expected string to be written first, got %v (%v)", value, err)
+	}
+
+	value, err := reader.Read()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob := value.(*TaggedValue).Value.(*Blob)
+
+	if !bytes.Equal(blob.Data, payload) {
+		t.Fatalf("Expected payload of %d bytes, got %d", len(payload), len(blob.Data))
+	}
+}
@@ -0,0 +1,552 @@
+// Package flyclient is a client for Fly servers.
+//
+// A Client is safe for concurrent use: commands sent from several goroutines,
+// and the streams they open, share a single connection.
+package flyclient
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+// Number of bytes a read stream can receive before they're read, unless
+// Options.Window says otherwise
+const DefaultWindow = 256 * 1024
+
+const defaultMaxBlob = 32 * 1024
+
+// Extensions requested from the server (see HELLO)
+var extensions = []string{"CHECKSUM", "DELTA", "TAGS", "TIMESTAMPS"}
+
+var ErrClosed = errors.New("flyclient: connection closed")
+
+type Options struct {
+	// Connect without TLS, for servers started with -notls
+	NoTLS bool
+
+	// Hex-encoded SHA-256 digest of the server's certificate. The connection
+	// is refused if the certificate doesn't match.
+	Fingerprint string
+
+	// Called with the fingerprint of the server's certificate when Fingerprint
+	// is empty. Returning an error refuses the connection. When neither is set,
+	// Dial fails with a *FingerprintError.
+	VerifyFingerprint func(fingerprint string) error
+
+	// Number of bytes a read stream can receive before they're read. Pass a
+	// negative number to let the server send as fast as it can.
+	Window int
+
+	// Compress the data of read and write streams with gzip, which pays off
+	// on slow links when the files compress well
+	Compress bool
+}
+
+type Client struct {
+	conn       net.Conn
+	reader     *wire.WireReader
+	extensions map[string]bool
+	maxBlob    int
+	window     int
+	compress   bool
+
+	writeLock sync.Mutex
+	writer    *wire.WireWriter
+
+	lock     sync.Mutex
+	err      error                // set once the connection is lost
+	nextTag  int                  // protected by lock
+	pending  map[string]*request  // by request ID, protected by lock
+	untagged []*request           // in the order they were sent, protected by lock
+	streams  map[int]*stream      // protected by lock
+	orphans  map[int][]wire.Value // protected by lock
+	opening  int                  // commands waiting for a stream ID, protected by lock
+}
+
+type request struct {
+	// Nil when the response is ignored
+	reply chan wire.Value
+
+	// For commands that open a stream, registered as soon as the response
+	// comes in, before any other frame is dispatched
+	stream *stream
+}
+
+// An error returned by the server. NOTFOUND errors match fs.ErrNotExist and
+// DENIED errors match fs.ErrPermission, so they can be checked with errors.Is.
+type Error struct {
+	Code    string
+	Message string
+}
+
+// Connects to a Fly server, addr being host:port
+func Dial(addr string, opts Options) (*Client, error) {
+	conn, err := dial(addr, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newClient(conn, opts)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func newClient(conn net.Conn, opts Options) (*Client, error) {
+	c := &Client{
+		conn:       conn,
+		reader:     wire.NewReader(conn),
+		writer:     wire.NewWriter(conn),
+		extensions: make(map[string]bool),
+		maxBlob:    defaultMaxBlob,
+		window:     opts.Window,
+		compress:   opts.Compress,
+		pending:    make(map[string]*request),
+		streams:    make(map[int]*stream),
+		orphans:    make(map[int][]wire.Value),
+	}
+
+	if c.window == 0 {
+		c.window = DefaultWindow
+	}
+
+	if err := c.hello(); err != nil {
+		return nil, err
+	}
+
+	c.reader.Pool = wire.NewBlobPool(c.maxBlob)
+	go c.readLoop()
+
+	// Servers that don't know HELLO don't know WINDOW either, their streams
+	// then send data without waiting for credit
+	if c.window > 0 {
+		if _, err := c.call("WINDOW", c.window); err != nil {
+			if e, ok := err.(*Error); !ok || e.Code != "CMD" {
+				c.Close()
+				return nil, err
+			}
+
+			c.window = 0
+		}
+	}
+
+	return c, nil
+}
+
+// Runs before the read loop is started, servers that don't know HELLO are
+// assumed to support no extension.
+func (c *Client) hello() error {
+	names := make([]wire.Value, len(extensions))
+
+	for i, name := range extensions {
+		names[i] = wire.NewString(name)
+	}
+
+	cmd := wire.NewArray([]wire.Value{
+		wire.NewString("HELLO"),
+		wire.NewInteger(1),
+		wire.NewArray(names),
+	})
+
+	if err := c.writer.Write(cmd); err != nil {
+		return err
+	}
+
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+
+	val, err := c.reader.Read()
+
+	if err != nil {
+		return err
+	}
+
+	if wireErr, ok := val.(*wire.Error); ok {
+		if wireErr.Code == "CMD" {
+			return nil
+		}
+
+		return newError(wireErr)
+	}
+
+	m, ok := val.(*wire.Map)
+
+	if !ok {
+		return fmt.Errorf("flyclient: unexpected %s in response to HELLO", val.Name())
+	}
+
+	if arr, ok := m.Get("extensions"); ok {
+		if arr, ok := arr.(*wire.Array); ok {
+			for _, v := range arr.Values {
+				if name, ok := v.(*wire.String); ok {
+					c.extensions[name.Value] = true
+				}
+			}
+		}
+	}
+
+	if size, ok := m.Get("maxblob"); ok {
+		if size, ok := size.(*wire.Integer); ok && size.Value > 0 {
+			c.maxBlob = int(size.Value)
+		}
+	}
+
+	return nil
+}
+
+// Returns true if the extension was negotiated with the server
+func (c *Client) HasExtension(name string) bool {
+	return c.extensions[name]
+}
+
+// Waits for the pending commands, then closes the connection. Streams that
+// are still open fail with ErrClosed.
+func (c *Client) Close() error {
+	_, err := c.call("QUIT")
+	c.fail(ErrClosed)
+
+	if errors.Is(err, ErrClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func (c *Client) Ping() error {
+	_, err := c.call("PING")
+	return err
+}
+
+// Sends the command and waits for the response, errors sent by the server
+// are returned as *Error
+func (c *Client) call(name string, args ...interface{}) (wire.Value, error) {
+	req := &request{reply: make(chan wire.Value, 1)}
+
+	if err := c.send(req, name, args...); err != nil {
+		return nil, err
+	}
+
+	return c.wait(req)
+}
+
+func (c *Client) wait(req *request) (wire.Value, error) {
+	val, ok := <-req.reply
+
+	if !ok {
+		return nil, c.connErr()
+	}
+
+	if wireErr, ok := val.(*wire.Error); ok {
+		return nil, newError(wireErr)
+	}
+
+	return val, nil
+}
+
+// Calls a command that returns OK
+func (c *Client) callOK(name string, args ...interface{}) error {
+	_, err := c.call(name, args...)
+	return err
+}
+
+// Commands are tagged with a request ID when the server supports it, so that
+// they can run alongside each other
+func (c *Client) send(req *request, name string, args ...interface{}) error {
+	cmd, err := command(name, args)
+
+	if err != nil {
+		return err
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.lock.Lock()
+
+	if c.err != nil {
+		c.lock.Unlock()
+		return c.err
+	}
+
+	var val wire.Value = cmd
+
+	if c.extensions["TAGS"] {
+		c.nextTag++
+		tag := "r" + strconv.Itoa(c.nextTag)
+		c.pending[tag] = req
+		val = wire.NewTaggedValue(cmd, tag)
+	} else {
+		c.untagged = append(c.untagged, req)
+	}
+
+	if req.stream != nil {
+		c.opening++
+	}
+
+	c.lock.Unlock()
+
+	return c.flush(val)
+}
+
+// Writes a value that isn't a command, e.g. a stream frame
+func (c *Client) write(val wire.Value) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if err := c.connErr(); err != nil {
+		return err
+	}
+
+	return c.flush(val)
+}
+
+// Must be called with writeLock held
+func (c *Client) flush(val wire.Value) error {
+	err := c.writer.Write(val)
+
+	if err == nil {
+		err = c.writer.Flush()
+	}
+
+	if err != nil {
+		c.fail(fmt.Errorf("flyclient: %w", err))
+		return c.connErr()
+	}
+
+	return nil
+}
+
+func command(name string, args []interface{}) (*wire.Array, error) {
+	values := make([]wire.Value, len(args)+1)
+	values[0] = wire.NewString(name)
+
+	for i, arg := range args {
+		j := i + 1
+
+		switch v := arg.(type) {
+		case wire.Value:
+			values[j] = v
+		case string:
+			values[j] = wire.NewString(v)
+		case int:
+			values[j] = wire.NewInteger(int64(v))
+		case int64:
+			values[j] = wire.NewInteger(v)
+		case bool:
+			values[j] = wire.NewBoolean(v)
+		case []string:
+			arr := make([]wire.Value, len(v))
+
+			for k, s := range v {
+				arr[k] = wire.NewString(s)
+			}
+
+			values[j] = wire.NewArray(arr)
+		default:
+			return nil, fmt.Errorf("flyclient: unsupported argument type %T", arg)
+		}
+	}
+
+	return wire.NewArray(values), nil
+}
+
+func (c *Client) readLoop() {
+	for {
+		val, err := c.reader.Read()
+
+		if err != nil {
+			c.fail(fmt.Errorf("flyclient: %w", err))
+			return
+		}
+
+		c.dispatch(val)
+	}
+}
+
+// Numeric tags are stream IDs, other tags are request IDs
+func (c *Client) dispatch(val wire.Value) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		release(val)
+		return
+	}
+
+	tagged, ok := val.(*wire.TaggedValue)
+
+	if !ok {
+		if len(c.untagged) == 0 {
+			return
+		}
+
+		req := c.untagged[0]
+		c.untagged[0] = nil
+		c.untagged = c.untagged[1:]
+		c.respond(req, val)
+		return
+	}
+
+	id, err := strconv.Atoi(tagged.Tag)
+
+	if err != nil {
+		if req, ok := c.pending[tagged.Tag]; ok {
+			delete(c.pending, tagged.Tag)
+			c.respond(req, tagged.Value)
+		}
+
+		return
+	}
+
+	if s, ok := c.streams[id]; ok {
+		s.push(tagged.Value)
+
+		if isLast(tagged.Value) {
+			delete(c.streams, id)
+		}
+
+		return
+	}
+
+	// The server may send the first frames of a stream before the ID. Frames
+	// that can't be for a stream being opened are leftovers of a stream that's
+	// gone (e.g. errors for frames sent after it failed), they're dropped so
+	// that they don't end up in the next stream that gets the same ID.
+	if c.opening == 0 {
+		release(tagged.Value)
+		return
+	}
+
+	c.orphans[id] = append(c.orphans[id], tagged.Value)
+}
+
+// Must be called with lock held
+func (c *Client) respond(req *request, val wire.Value) {
+	if req.stream != nil {
+		if id, ok := streamID(val); ok {
+			c.attach(int(id.Value), req.stream)
+		}
+
+		c.opening--
+
+		if c.opening == 0 {
+			c.dropOrphans()
+		}
+	}
+
+	if req.reply == nil {
+		release(val)
+		return
+	}
+
+	req.reply <- val
+}
+
+// Must be called with lock held
+func (c *Client) attach(id int, s *stream) {
+	frames := c.orphans[id]
+	delete(c.orphans, id)
+
+	for _, f := range frames {
+		s.push(f)
+
+		if isLast(f) {
+			return
+		}
+	}
+
+	c.streams[id] = s
+}
+
+// Must be called with lock held
+func (c *Client) dropOrphans() {
+	for id, frames := range c.orphans {
+		for _, f := range frames {
+			release(f)
+		}
+
+		delete(c.orphans, id)
+	}
+}
+
+// Must be called once the stream's ID has been closed on the server
+func (c *Client) detach(id int, s *stream) {
+	c.lock.Lock()
+
+	if c.streams[id] == s {
+		delete(c.streams, id)
+	}
+
+	c.lock.Unlock()
+}
+
+// Closes the connection, pending commands and open streams fail with err
+func (c *Client) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	c.conn.Close()
+
+	for _, req := range c.pending {
+		if req.reply != nil {
+			close(req.reply)
+		}
+	}
+
+	for _, req := range c.untagged {
+		if req.reply != nil {
+			close(req.reply)
+		}
+	}
+
+	for _, s := range c.streams {
+		s.fail(err)
+	}
+
+	c.pending = nil
+	c.untagged = nil
+	c.streams = nil
+	c.orphans = nil
+}
+
+func (c *Client) connErr() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+func newError(e *wire.Error) *Error {
+	return &Error{Code: e.Code, Message: e.Message}
+}
+
+func (e *Error) Error() string {
+	return e.Code + " " + e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.Code == "NOTFOUND"
+	case fs.ErrPermission:
+		return e.Code == "DENIED"
+	}
+
+	return false
+}
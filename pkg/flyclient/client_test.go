@@ -0,0 +1,377 @@
+package flyclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ngagnon/flybywire/internal/delta"
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+// Answers HELLO and WINDOW, the other commands and frames are passed to handle
+func fakeServer(t *testing.T, handle func(w *wire.WireWriter, val *wire.TaggedValue)) *Client {
+	clientConn, serverConn := net.Pipe()
+
+	go func() {
+		r := wire.NewReader(serverConn)
+		w := wire.NewWriter(serverConn)
+
+		for {
+			val, err := r.Read()
+
+			if err != nil {
+				return
+			}
+
+			if arr, ok := val.(*wire.Array); ok && commandName(arr) == "HELLO" {
+				hello := wire.NewMap(nil)
+				hello.Set("extensions", wire.NewArray([]wire.Value{
+					wire.NewString("CHECKSUM"),
+					wire.NewString("DELTA"),
+					wire.NewString("TAGS"),
+				}))
+				w.Write(hello)
+				w.Flush()
+				continue
+			}
+
+			tagged := val.(*wire.TaggedValue)
+
+			if arr, ok := tagged.Value.(*wire.Array); ok && commandName(arr) == "WINDOW" {
+				w.Write(wire.NewTaggedValue(wire.OK, tagged.Tag))
+				w.Flush()
+				continue
+			}
+
+			handle(w, tagged)
+			w.Flush()
+		}
+	}()
+
+	c, err := newClient(clientConn, Options{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { c.fail(ErrClosed) })
+	return c
+}
+
+func commandName(cmd *wire.Array) string {
+	return cmd.Values[0].(*wire.String).Value
+}
+
+func TestResponsesOutOfOrder(t *testing.T) {
+	var first *wire.TaggedValue
+
+	c := fakeServer(t, func(w *wire.WireWriter, val *wire.TaggedValue) {
+		if first == nil {
+			first = val
+			return
+		}
+
+		for _, v := range []*wire.TaggedValue{val, first} {
+			name := commandName(v.Value.(*wire.Array))
+			w.Write(wire.NewTaggedValue(wire.NewString(name), v.Tag))
+		}
+	})
+
+	token := make(chan string)
+
+	go func() {
+		s, _ := c.Token()
+		token <- s
+	}()
+
+	// Make sure TOKEN is sent first
+	time.Sleep(50 * time.Millisecond)
+
+	user, err := c.WhoAmI()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != "WHOAMI" {
+		t.Fatalf("Expected response to WHOAMI, got %s", user)
+	}
+
+	if s := <-token; s != "TOKEN" {
+		t.Fatalf("Expected response to TOKEN, got %s", s)
+	}
+}
+
+func TestServerWithoutHello(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+
+	go func() {
+		r := wire.NewReader(serverConn)
+		w := wire.NewWriter(serverConn)
+
+		for {
+			val, err := r.Read()
+
+			if err != nil {
+				return
+			}
+
+			if commandName(val.(*wire.Array)) == "WHOAMI" {
+				w.Write(wire.NewString("joe"))
+			} else {
+				w.Write(wire.NewError("CMD", "Unknown command"))
+			}
+
+			w.Flush()
+		}
+	}()
+
+	c, err := newClient(clientConn, Options{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { c.fail(ErrClosed) })
+
+	if c.window != 0 {
+		t.Fatalf("Expected streams not to wait for credit, window was %d", c.window)
+	}
+
+	if user, err := c.WhoAmI(); err != nil || user != "joe" {
+		t.Fatalf("Expected joe, got %q (%v)", user, err)
+	}
+}
+
+func TestFramesBeforeStreamID(t *testing.T) {
+	c := fakeServer(t, func(w *wire.WireWriter, val *wire.TaggedValue) {
+		w.Write(wire.NewTaggedValue(wire.NewBlob([]byte("hello ")), "4"))
+		w.Write(wire.NewTaggedValue(wire.NewBlob([]byte("world")), "4"))
+		w.Write(wire.NewTaggedValue(wire.Null, "4"))
+		w.Write(wire.NewTaggedValue(wire.NewInteger(4), val.Tag))
+	})
+
+	r, err := c.Open("/some/file.txt")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "hello world" {
+		t.Fatalf("Expected hello world, got %q", data)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaleFramesDropped(t *testing.T) {
+	c := fakeServer(t, func(w *wire.WireWriter, val *wire.TaggedValue) {
+		if commandName(val.Value.(*wire.Array)) == "PING" {
+			// Left over from a stream that failed
+			w.Write(wire.NewTaggedValue(wire.NewError("ARG", "Stream is closed"), "4"))
+			w.Write(wire.NewTaggedValue(wire.NewString("PONG"), val.Tag))
+			return
+		}
+
+		w.Write(wire.NewTaggedValue(wire.NewInteger(4), val.Tag))
+		w.Write(wire.NewTaggedValue(wire.NewBlob([]byte("hello")), "4"))
+		w.Write(wire.NewTaggedValue(wire.Null, "4"))
+	})
+
+	if err := c.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.Open("/some/file.txt")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "hello" {
+		t.Fatalf("Expected hello, got %q", data)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	received := new(bytes.Buffer)
+
+	c := fakeServer(t, func(w *wire.WireWriter, val *wire.TaggedValue) {
+		if val.Tag != "2" {
+			w.Write(wire.NewTaggedValue(wire.NewInteger(2), val.Tag))
+			return
+		}
+
+		switch v := val.Value.(type) {
+		case *wire.Blob:
+			received.Write(v.Data)
+		case *wire.String:
+			sum := sha256.Sum256(received.Bytes())
+
+			if v.Value != "SHA256:"+hex.EncodeToString(sum[:]) {
+				w.Write(wire.NewTaggedValue(wire.NewError("CHECKSUM", "Checksum mismatch"), "2"))
+				return
+			}
+
+			ack := wire.NewArray([]wire.Value{
+				wire.NewInteger(int64(received.Len())),
+				wire.NewTimestamp(time.Now()),
+			})
+
+			w.Write(wire.NewTaggedValue(ack, "2"))
+		}
+	})
+
+	f, err := c.Create("/some/file.txt")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received.Bytes(), data) {
+		t.Fatalf("Expected %d bytes to be written, got %d", len(data), received.Len())
+	}
+}
+
+func TestErrorIsNotExist(t *testing.T) {
+	c := fakeServer(t, func(w *wire.WireWriter, val *wire.TaggedValue) {
+		w.Write(wire.NewTaggedValue(wire.NewError("NOTFOUND", "No such file or directory"), val.Tag))
+	})
+
+	err := c.Delete("/some/file.txt")
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist, got %v", err)
+	}
+
+	var flyErr *Error
+
+	if !errors.As(err, &flyErr) || flyErr.Code != "NOTFOUND" {
+		t.Fatalf("Expected NOTFOUND, got %v", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	c := fakeServer(t, func(w *wire.WireWriter, val *wire.TaggedValue) {
+		cmd := val.Value.(*wire.Array)
+
+		if commandName(cmd) != "SYNC" {
+			return
+		}
+
+		if sigs := cmd.Values[3].(*wire.Table); sigs.RowCount != 2 {
+			w.Write(wire.NewTaggedValue(wire.NewError("ARG", "Expected 2 signatures"), val.Tag))
+			return
+		}
+
+		w.Write(wire.NewTaggedValue(wire.NewInteger(3), val.Tag))
+		w.Write(wire.NewTaggedValue(wire.NewInteger(1), "3"))
+		w.Write(wire.NewTaggedValue(wire.NewBlob([]byte("new")), "3"))
+		w.Write(wire.NewTaggedValue(wire.NewInteger(0), "3"))
+		w.Write(wire.NewTaggedValue(wire.Null, "3"))
+	})
+
+	a := bytes.Repeat([]byte("a"), 700)
+	b := bytes.Repeat([]byte("b"), 700)
+	base := append(append([]byte{}, a...), b...)
+	out := new(bytes.Buffer)
+
+	if err := c.Sync("/some/file.txt", bytes.NewReader(base), int64(len(base)), out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append(append(append([]byte{}, b...), "new"...), a...)
+
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Fatalf("Expected %d bytes, got %d", len(expected), out.Len())
+	}
+}
+
+func TestPatch(t *testing.T) {
+	a := bytes.Repeat([]byte("a"), 700)
+	b := bytes.Repeat([]byte("b"), 700)
+	base := append(append([]byte{}, a...), b...)
+	received := new(bytes.Buffer)
+	literals := 0
+
+	c := fakeServer(t, func(w *wire.WireWriter, val *wire.TaggedValue) {
+		if val.Tag != "2" {
+			sigs, _ := delta.Signatures(bytes.NewReader(base), 700)
+			table := &wire.Table{ColCount: 2}
+
+			for i := range sigs {
+				table.Add([]wire.Value{wire.NewInteger(int64(sigs[i].Weak)), wire.NewBlob(sigs[i].Strong[:])})
+			}
+
+			w.Write(wire.NewTaggedValue(wire.NewArray([]wire.Value{wire.NewInteger(2), table}), val.Tag))
+			return
+		}
+
+		switch v := val.Value.(type) {
+		case *wire.Integer:
+			received.Write(base[v.Value*700 : (v.Value+1)*700])
+		case *wire.Blob:
+			received.Write(v.Data)
+			literals += len(v.Data)
+		case *wire.String:
+			sum := sha256.Sum256(received.Bytes())
+
+			if v.Value != "SHA256:"+hex.EncodeToString(sum[:]) {
+				w.Write(wire.NewTaggedValue(wire.NewError("CHECKSUM", "Checksum mismatch"), "2"))
+				return
+			}
+
+			ack := wire.NewArray([]wire.Value{
+				wire.NewInteger(int64(received.Len())),
+				wire.NewTimestamp(time.Now()),
+			})
+
+			w.Write(wire.NewTaggedValue(ack, "2"))
+		}
+	})
+
+	data := append(append(append([]byte{}, b...), "new"...), a...)
+
+	if err := c.Patch("/some/file.txt", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(received.Bytes(), data) {
+		t.Fatalf("Expected %d bytes to be written, got %d", len(data), received.Len())
+	}
+
+	if literals != 3 {
+		t.Fatalf("Expected 3 bytes of literal data, got %d", literals)
+	}
+}
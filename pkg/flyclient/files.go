@@ -0,0 +1,188 @@
+package flyclient
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+// Describes a remote file or folder. The server doesn't share permissions,
+// so Mode always reports 0644 for files and 0755 for folders.
+type FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+// Lists the contents of a folder. When given a file, returns just that file.
+func (c *Client) List(remotePath string) ([]*FileInfo, error) {
+	val, err := c.call("LIST", remotePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	table, ok := val.(*wire.Table)
+
+	if !ok {
+		return nil, fmt.Errorf("flyclient: unexpected %s in response to LIST", val.Name())
+	}
+
+	files := make([]*FileInfo, 0, table.RowCount)
+
+	for i := 0; i < table.RowCount; i++ {
+		info, err := parseFileInfo(table.Row(i))
+
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, info)
+	}
+
+	return files, nil
+}
+
+// Returns information about a file or folder. The server has no STAT command:
+// the path is listed, and is a file when LIST returns just that file. When it
+// returns a single file with the same name as the path, which could also be
+// the contents of a folder, the path is looked up in the listing of its parent
+// folder. The modification time of folders isn't known, unless they were
+// looked up that way.
+func (c *Client) Stat(remotePath string) (*FileInfo, error) {
+	info, _, err := c.stat(remotePath)
+	return info, err
+}
+
+// Also returns the contents of the folder, when the path is a folder
+func (c *Client) stat(remotePath string) (*FileInfo, []*FileInfo, error) {
+	clean := path.Clean("/" + remotePath)
+	name := path.Base(clean)
+	files, err := c.List(remotePath)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	folder := &FileInfo{name: name, dir: true}
+
+	if clean == "/" || len(files) != 1 || files[0].dir || files[0].name != name {
+		return folder, files, nil
+	}
+
+	siblings, err := c.List(path.Dir(clean))
+
+	if err != nil {
+		return files[0], nil, nil
+	}
+
+	for _, f := range siblings {
+		if f.name != name {
+			continue
+		}
+
+		if f.dir {
+			return f, files, nil
+		}
+
+		return f, nil, nil
+	}
+
+	return files[0], nil, nil
+}
+
+func (c *Client) Mkdir(remotePath string) error {
+	return c.callOK("MKDIR", remotePath)
+}
+
+// Sets the modification time of a file to now, creating it if needed
+func (c *Client) Touch(remotePath string) error {
+	return c.callOK("TOUCH", remotePath)
+}
+
+// Deletes a file or folder
+func (c *Client) Delete(remotePath string) error {
+	return c.callOK("DEL", remotePath)
+}
+
+// Moves (or renames) a file or folder
+func (c *Client) Move(from string, to string) error {
+	return c.callOK("MOVE", from, to)
+}
+
+// Rows have 4 columns: type, name, size and modification time
+func parseFileInfo(row []wire.Value) (*FileInfo, error) {
+	if len(row) != 4 {
+		return nil, fmt.Errorf("flyclient: expected 4 columns in LIST, got %d", len(row))
+	}
+
+	ftype, ok1 := row[0].(*wire.String)
+	name, ok2 := row[1].(*wire.String)
+
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("flyclient: unexpected %s, %s in LIST", row[0].Name(), row[1].Name())
+	}
+
+	info := &FileInfo{name: name.Value, dir: ftype.Value == "D"}
+
+	if size, ok := row[2].(*wire.Integer); ok {
+		info.size = size.Value
+	}
+
+	// Servers that don't support the TIMESTAMPS extension send a string
+	switch mtime := row[3].(type) {
+	case *wire.Timestamp:
+		info.modTime = mtime.Value
+	case *wire.String:
+		t, err := time.Parse(time.RFC3339Nano, mtime.Value)
+
+		if err != nil {
+			return nil, fmt.Errorf("flyclient: invalid modification time: %s", mtime.Value)
+		}
+
+		info.modTime = t
+	}
+
+	return info, nil
+}
+
+func (f *FileInfo) Name() string {
+	return f.name
+}
+
+func (f *FileInfo) Size() int64 {
+	return f.size
+}
+
+func (f *FileInfo) Mode() fs.FileMode {
+	if f.dir {
+		return fs.ModeDir | 0755
+	}
+
+	return 0644
+}
+
+func (f *FileInfo) ModTime() time.Time {
+	return f.modTime
+}
+
+func (f *FileInfo) IsDir() bool {
+	return f.dir
+}
+
+func (f *FileInfo) Sys() interface{} {
+	return nil
+}
+
+// Type and Info make FileInfo an fs.DirEntry as well
+func (f *FileInfo) Type() fs.FileMode {
+	return f.Mode().Type()
+}
+
+func (f *FileInfo) Info() (fs.FileInfo, error) {
+	return f, nil
+}
@@ -0,0 +1,209 @@
+package flyclient
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// A read-only view of a folder on the server, to be used with the io/fs
+// package (fs.WalkDir, template.ParseFS, http.FS, etc.)
+type FS struct {
+	c    *Client
+	root string
+}
+
+// An open remote file, the data is only streamed once it's read
+type file struct {
+	fsys   *FS
+	name   string
+	info   *FileInfo
+	stream *ReadStream
+	offset int64
+}
+
+// An open remote folder
+type dir struct {
+	info    *FileInfo
+	entries []*FileInfo
+}
+
+var errNotDir = errors.New("not a directory")
+
+// Returns a file system rooted at the given remote folder
+func (c *Client) FS(root string) *FS {
+	return &FS{c: c, root: path.Clean("/" + root)}
+}
+
+func (fsys *FS) path(op string, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	return path.Join(fsys.root, name), nil
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	remotePath, err := fsys.path("open", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, entries, err := fsys.c.stat(remotePath)
+
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	if info.dir {
+		return &dir{info: info, entries: sortEntries(entries)}, nil
+	}
+
+	return &file{fsys: fsys, name: name, info: info}, nil
+}
+
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	remotePath, err := fsys.path("stat", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, _, err := fsys.c.stat(remotePath)
+
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return info, nil
+}
+
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	remotePath, err := fsys.path("readdir", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, entries, err := fsys.c.stat(remotePath)
+
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	if !info.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+
+	return dirEntries(sortEntries(entries)), nil
+}
+
+// LIST only sorts the files when the table isn't streamed
+func sortEntries(entries []*FileInfo) []*FileInfo {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	return entries
+}
+
+func dirEntries(files []*FileInfo) []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(files))
+
+	for i, f := range files {
+		entries[i] = f
+	}
+
+	return entries
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+
+	if f.stream == nil {
+		stream, err := f.fsys.c.OpenRange(path.Join(f.fsys.root, f.name), f.offset, -1)
+
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+
+		f.stream = stream
+	}
+
+	n, err := f.stream.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// Seeking closes the stream, a new one is opened at the new offset on the next read
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset != f.offset && f.stream != nil {
+		f.stream.Close()
+		f.stream = nil
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Close() error {
+	if f.stream == nil {
+		return nil
+	}
+
+	err := f.stream.Close()
+	f.stream = nil
+	return err
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return dirEntries(entries), nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return dirEntries(entries), nil
+}
+
+func (d *dir) Close() error {
+	return nil
+}
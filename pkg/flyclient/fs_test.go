@@ -0,0 +1,183 @@
+package flyclient
+
+import (
+	"io/fs"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+// Serves LIST and STREAM R from an in-memory file system
+func fsServer(t *testing.T, fsys fstest.MapFS) *Client {
+	nextId := 0
+
+	return fakeServer(t, func(w *wire.WireWriter, val *wire.TaggedValue) {
+		cmd := val.Value.(*wire.Array)
+
+		switch commandName(cmd) {
+		case "LIST":
+			name := strings.TrimPrefix(cmd.Values[1].(*wire.String).Value, "/")
+
+			if name == "" {
+				name = "."
+			}
+
+			w.Write(wire.NewTaggedValue(listFS(fsys, name), val.Tag))
+		case "STREAM":
+			name := strings.TrimPrefix(cmd.Values[2].(*wire.String).Value, "/")
+			data, err := fs.ReadFile(fsys, name)
+
+			if err != nil {
+				w.Write(wire.NewTaggedValue(wire.NewError("NOTFOUND", "No such file or directory"), val.Tag))
+				return
+			}
+
+			if len(cmd.Values) == 5 {
+				data = data[cmd.Values[4].(*wire.Integer).Value:]
+			}
+
+			nextId++
+			tag := strconv.Itoa(nextId)
+			w.Write(wire.NewTaggedValue(wire.NewInteger(int64(nextId)), val.Tag))
+			w.Write(wire.NewTaggedValue(wire.NewBlob(data), tag))
+			w.Write(wire.NewTaggedValue(wire.Null, tag))
+		default:
+			w.Write(wire.NewTaggedValue(wire.OK, val.Tag))
+		}
+	})
+}
+
+func listFS(fsys fstest.MapFS, name string) wire.Value {
+	info, err := fs.Stat(fsys, name)
+
+	if err != nil {
+		return wire.NewError("NOTFOUND", "No such file or directory")
+	}
+
+	table := &wire.Table{ColCount: 4}
+	infos := []fs.FileInfo{info}
+
+	if info.IsDir() {
+		entries, _ := fs.ReadDir(fsys, name)
+		infos = infos[:0]
+
+		for _, e := range entries {
+			info, _ := e.Info()
+			infos = append(infos, info)
+		}
+	}
+
+	for _, info := range infos {
+		ftype := "F"
+		var size wire.Value = wire.NewInteger(info.Size())
+
+		if info.IsDir() {
+			ftype = "D"
+			size = wire.Null
+		}
+
+		table.Add([]wire.Value{
+			wire.NewString(ftype),
+			wire.NewString(info.Name()),
+			size,
+			wire.NewTimestamp(info.ModTime()),
+		})
+	}
+
+	return table
+}
+
+func TestFS(t *testing.T) {
+	mtime := time.Date(2021, 6, 15, 0, 8, 20, 0, time.UTC)
+
+	c := fsServer(t, fstest.MapFS{
+		"hello.txt":          {Data: []byte("hello world"), ModTime: mtime},
+		"empty.txt":          {ModTime: mtime},
+		"docs/a.md":          {Data: []byte("# A"), ModTime: mtime},
+		"docs/nested/b.md":   {Data: []byte("# B"), ModTime: mtime},
+		"docs/nested/c.html": {Data: []byte("<p>C</p>"), ModTime: mtime},
+	})
+
+	if err := fstest.TestFS(c.FS("/"), "hello.txt", "empty.txt", "docs/a.md", "docs/nested/c.html"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// LIST on the folder returns a single file with the folder's name
+func TestFSFolderWithSameNameFile(t *testing.T) {
+	mtime := time.Date(2021, 6, 15, 0, 8, 20, 0, time.UTC)
+
+	c := fsServer(t, fstest.MapFS{
+		"a/a": {Data: []byte("hello"), ModTime: mtime},
+	})
+
+	info, err := c.Stat("/a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.IsDir() {
+		t.Fatal("Expected /a to be a folder")
+	}
+
+	if info, err = c.Stat("/a/a"); err != nil || info.IsDir() {
+		t.Fatalf("Expected /a/a to be a file, got %v, %v", info, err)
+	}
+
+	if err := fstest.TestFS(c.FS("/"), "a/a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The parent folder is only listed when LIST on the path returns a single file
+// with the same name
+func TestStatListsPathFirst(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs/a.md": {Data: []byte("# A")},
+		"docs/b.md": {Data: []byte("# B")},
+		"c/c":       {Data: []byte("C")},
+	}
+
+	var listed []string
+	c := fakeServer(t, func(w *wire.WireWriter, val *wire.TaggedValue) {
+		name := strings.TrimPrefix(val.Value.(*wire.Array).Values[1].(*wire.String).Value, "/")
+		listed = append(listed, name)
+
+		if name == "" {
+			name = "."
+		}
+
+		w.Write(wire.NewTaggedValue(listFS(fsys, name), val.Tag))
+	})
+
+	for _, tt := range []struct {
+		path   string
+		dir    bool
+		listed int
+	}{
+		{"/docs/a.md", false, 2},
+		{"/docs", true, 1},
+		{"/c", true, 2},
+		{"/c/c", false, 2},
+	} {
+		listed = nil
+		info, err := c.Stat(tt.path)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.IsDir() != tt.dir {
+			t.Fatalf("Expected IsDir() of %s to be %v", tt.path, tt.dir)
+		}
+
+		if len(listed) != tt.listed {
+			t.Fatalf("Expected %d LIST for %s, got %v", tt.listed, tt.path, listed)
+		}
+	}
+}
@@ -0,0 +1,531 @@
+package flyclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/ngagnon/flybywire/internal/compress"
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+// Frames received for a stream, waiting to be read. The read loop never
+// blocks on a stream: read streams are kept from running too far ahead by
+// the credit they're granted.
+type stream struct {
+	lock      sync.Mutex
+	frames    []wire.Value
+	err       error
+	discarded bool
+	signal    chan struct{}
+}
+
+// Reads a remote file, see Client.Open
+type ReadStream struct {
+	c      *Client
+	id     int
+	s      *stream
+	chunk  *wire.Blob
+	buf    []byte // unread part of chunk
+	err    error
+	done   bool // the server has sent the whole file (or an error)
+	credit int  // bytes received since credit was last granted
+
+	// Set when the chunks are compressed
+	decoder *compress.Decoder
+	decoded bytes.Buffer
+}
+
+// Writes a remote file, see Client.Create
+type WriteStream struct {
+	c    *Client
+	id   int
+	tag  string
+	s    *stream
+	buf  []byte
+	n    int
+	hash hash.Hash
+	err  error
+
+	// Set by Patch, which hashes the whole file rather than the data sent
+	delta bool
+
+	// Set when the chunks are compressed
+	encoder    *compress.Encoder
+	compressed []byte
+}
+
+func newStream() *stream {
+	return &stream{signal: make(chan struct{}, 1)}
+}
+
+func (s *stream) push(val wire.Value) {
+	s.lock.Lock()
+
+	if s.discarded {
+		s.lock.Unlock()
+		release(val)
+		return
+	}
+
+	s.frames = append(s.frames, val)
+	s.lock.Unlock()
+	s.notify()
+}
+
+func (s *stream) fail(err error) {
+	s.lock.Lock()
+
+	if s.err == nil {
+		s.err = err
+	}
+
+	s.lock.Unlock()
+	s.notify()
+}
+
+func (s *stream) notify() {
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Waits for the next frame. Frames that were received before the connection
+// was lost are returned first.
+func (s *stream) next() (wire.Value, error) {
+	for {
+		val, err := s.poll()
+
+		if val != nil || err != nil {
+			return val, err
+		}
+
+		<-s.signal
+	}
+}
+
+// Returns the next frame if there is one, without waiting
+func (s *stream) poll() (wire.Value, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.frames) > 0 {
+		val := s.frames[0]
+		s.frames[0] = nil
+		s.frames = s.frames[1:]
+		return val, nil
+	}
+
+	return nil, s.err
+}
+
+// Drops the frames received so far, along with any frame received later
+func (s *stream) discard() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, val := range s.frames {
+		release(val)
+	}
+
+	s.frames = nil
+	s.discarded = true
+}
+
+// Streams end with a null, an error, or an acknowledgement (for write streams)
+func isLast(val wire.Value) bool {
+	switch val.(type) {
+	case *wire.Error, *wire.Array:
+		return true
+	}
+
+	return val == wire.Null
+}
+
+func release(val wire.Value) {
+	if blob, ok := val.(*wire.Blob); ok {
+		blob.Release()
+	}
+}
+
+// Sends a command that opens a stream, and returns the stream ID. When the
+// server responds with an array, the values after the stream ID are returned
+// as well.
+func (c *Client) open(s *stream, name string, args ...interface{}) (int, []wire.Value, error) {
+	req := &request{reply: make(chan wire.Value, 1), stream: s}
+
+	if err := c.send(req, name, args...); err != nil {
+		return 0, nil, err
+	}
+
+	val, err := c.wait(req)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	id, ok := streamID(val)
+
+	if !ok {
+		return 0, nil, fmt.Errorf("flyclient: unexpected %s in response to %s", val.Name(), name)
+	}
+
+	if arr, ok := val.(*wire.Array); ok {
+		return int(id.Value), arr.Values[1:], nil
+	}
+
+	return int(id.Value), nil, nil
+}
+
+// Streams are opened with either an ID, or an array that starts with the ID
+func streamID(val wire.Value) (*wire.Integer, bool) {
+	if arr, ok := val.(*wire.Array); ok && len(arr.Values) > 0 {
+		val = arr.Values[0]
+	}
+
+	id, ok := val.(*wire.Integer)
+	return id, ok
+}
+
+// Opens a remote file for reading
+func (c *Client) Open(path string) (*ReadStream, error) {
+	return c.OpenRange(path, 0, -1)
+}
+
+// Opens a remote file for reading, starting at offset. Reading stops after
+// length bytes, unless length is negative.
+func (c *Client) OpenRange(path string, offset int64, length int64) (*ReadStream, error) {
+	args := []interface{}{"R", path}
+
+	if offset > 0 {
+		args = append(args, "OFFSET", offset)
+	}
+
+	if length >= 0 {
+		args = append(args, "LENGTH", length)
+	}
+
+	r := &ReadStream{c: c}
+
+	if c.compress {
+		args = append(args, "COMPRESS", compress.Gzip)
+		r.decoder, _ = compress.NewDecoder(compress.Gzip)
+	}
+
+	s := newStream()
+	id, _, err := c.open(s, "STREAM", args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.id = id
+	r.s = s
+	return r, nil
+}
+
+func (r *ReadStream) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		r.releaseChunk()
+		val, err := r.s.next()
+
+		if err != nil {
+			r.err = err
+			continue
+		}
+
+		switch v := val.(type) {
+		case *wire.Blob:
+			r.chunk = v
+			r.buf = v.Data
+			r.grant(len(v.Data))
+
+			if r.decoder != nil {
+				r.err = r.decode()
+			}
+		case *wire.Error:
+			r.done = true
+			r.err = newError(v)
+		default:
+			r.done = true
+			r.err = io.EOF
+
+			if val != wire.Null {
+				r.err = fmt.Errorf("flyclient: unexpected %s in read stream", val.Name())
+			}
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Replaces the compressed chunk with its contents
+func (r *ReadStream) decode() error {
+	r.decoded.Reset()
+	_, err := r.decoder.Decode(&r.decoded, r.chunk.Data)
+	r.releaseChunk()
+
+	if err != nil {
+		return fmt.Errorf("flyclient: could not decompress chunk: %w", err)
+	}
+
+	r.buf = r.decoded.Bytes()
+	return nil
+}
+
+// Lets the server send more data once half of the window has been received
+func (r *ReadStream) grant(n int) {
+	if r.c.window <= 0 || r.done {
+		return
+	}
+
+	r.credit += n
+
+	if r.credit >= r.c.window/2 {
+		// The stream may end before the server gets the credit, which is fine
+		r.c.send(&request{}, "CREDIT", r.id, r.credit)
+		r.credit = 0
+	}
+}
+
+func (r *ReadStream) releaseChunk() {
+	if r.chunk != nil {
+		r.chunk.Release()
+		r.chunk = nil
+		r.buf = nil
+	}
+}
+
+// Stops reading, the server is told to close the stream unless it already
+// sent the whole file
+func (r *ReadStream) Close() error {
+	r.releaseChunk()
+
+	if r.done {
+		return nil
+	}
+
+	r.done = true
+	r.err = ErrClosed
+	r.s.discard()
+	err := r.c.callOK("CLOSE", r.id)
+	r.c.detach(r.id, r.s)
+
+	// The stream ended by itself, in which case its last frame was already received
+	if e, ok := err.(*Error); ok && e.Code == "ARG" {
+		return nil
+	}
+
+	return err
+}
+
+// Creates (or replaces) a remote file. The file is only committed once the
+// stream is closed.
+func (c *Client) Create(path string) (*WriteStream, error) {
+	w, _, err := c.create(path)
+	return w, err
+}
+
+// Options are appended to the STREAM command. Also returns the values that
+// follow the stream ID in the response.
+func (c *Client) create(path string, opts ...interface{}) (*WriteStream, []wire.Value, error) {
+	args := append([]interface{}{"W", path}, opts...)
+	w := &WriteStream{
+		c:    c,
+		buf:  make([]byte, c.maxBlob),
+		hash: sha256.New(),
+	}
+
+	// Leave room for the compressed chunk to be a bit larger than the original
+	if c.compress {
+		args = append(args, "COMPRESS", compress.Gzip)
+		w.encoder, _ = compress.NewEncoder(compress.Gzip)
+		w.compressed = w.buf
+		w.buf = make([]byte, c.maxBlob-compress.Overhead)
+	}
+
+	s := newStream()
+	id, extra, err := c.open(s, "STREAM", args...)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.id = id
+	w.tag = strconv.Itoa(id)
+	w.s = s
+	return w, extra, nil
+}
+
+func (w *WriteStream) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		if w.err != nil {
+			return written, w.err
+		}
+
+		n := copy(w.buf[w.n:], p)
+		w.n += n
+		p = p[n:]
+		written += n
+
+		if w.n == len(w.buf) {
+			w.err = w.flush()
+		}
+	}
+
+	return written, nil
+}
+
+func (w *WriteStream) flush() error {
+	// Anything the server sends before the stream is committed is an error
+	if val, err := w.s.poll(); val != nil || err != nil {
+		return w.failed(val, err)
+	}
+
+	chunk := w.buf[:w.n]
+	w.n = 0
+
+	if !w.delta {
+		w.hash.Write(chunk)
+	}
+
+	if w.encoder != nil {
+		var err error
+
+		if chunk, err = w.encoder.Encode(w.compressed, chunk); err != nil {
+			return err
+		}
+	}
+
+	return w.c.write(wire.NewTaggedValue(wire.NewBlob(chunk), w.tag))
+}
+
+// Tells the server to copy a block of the file being replaced, see Patch
+func (w *WriteStream) writeBlock(block int) error {
+	if w.err == nil && w.n > 0 {
+		w.err = w.flush()
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+
+	if val, err := w.s.poll(); val != nil || err != nil {
+		w.err = w.failed(val, err)
+		return w.err
+	}
+
+	w.err = w.c.write(wire.NewTaggedValue(wire.NewInteger(int64(block)), w.tag))
+	return w.err
+}
+
+func (w *WriteStream) failed(val wire.Value, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if wireErr, ok := val.(*wire.Error); ok {
+		return newError(wireErr)
+	}
+
+	return fmt.Errorf("flyclient: unexpected %s in write stream", val.Name())
+}
+
+// Sends the rest of the file, then waits for the server to commit it. When
+// the server supports it, the file is verified against its SHA-256 digest.
+func (w *WriteStream) Close() error {
+	if w.err == nil && w.n > 0 {
+		w.err = w.flush()
+	}
+
+	if w.err != nil {
+		return w.Abort()
+	}
+
+	var end wire.Value = wire.Null
+
+	if w.c.HasExtension("CHECKSUM") {
+		end = wire.NewString("SHA256:" + hex.EncodeToString(w.hash.Sum(nil)))
+	}
+
+	if w.err = w.c.write(wire.NewTaggedValue(end, w.tag)); w.err != nil {
+		return w.err
+	}
+
+	val, err := w.s.next()
+
+	if err != nil {
+		w.err = err
+		return err
+	}
+
+	if _, ok := val.(*wire.Array); !ok {
+		w.err = w.failed(val, nil)
+		return w.err
+	}
+
+	w.err = ErrClosed
+	return nil
+}
+
+// Closes the stream without committing the file
+func (w *WriteStream) Abort() error {
+	err := w.err
+
+	if err == ErrClosed {
+		return nil
+	}
+
+	if err == nil {
+		w.err = ErrClosed
+	}
+
+	w.s.discard()
+	closeErr := w.c.callOK("CLOSE", w.id)
+	w.c.detach(w.id, w.s)
+
+	if err != nil {
+		return err
+	}
+
+	if e, ok := closeErr.(*Error); ok && e.Code == "ARG" {
+		return nil
+	}
+
+	return closeErr
+}
+
+// Copies a file on the server, the data doesn't go through the connection
+func (c *Client) Copy(from string, to string) error {
+	s := newStream()
+
+	if _, _, err := c.open(s, "COPY", from, to); err != nil {
+		return err
+	}
+
+	val, err := s.next()
+
+	if err != nil {
+		return err
+	}
+
+	if wireErr, ok := val.(*wire.Error); ok {
+		return newError(wireErr)
+	}
+
+	return nil
+}
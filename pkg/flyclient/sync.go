@@ -0,0 +1,181 @@
+package flyclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/ngagnon/flybywire/internal/delta"
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+// Writes a remote file to w, only transferring the parts that differ from
+// base, an older copy of the file that's size bytes long. Blocks of base
+// that are still in the remote file are copied from base.
+func (c *Client) Sync(remotePath string, base io.ReaderAt, size int64, w io.Writer) error {
+	blockSize := syncBlockSize(size)
+	sigs, err := delta.Signatures(io.NewSectionReader(base, 0, size), blockSize)
+
+	if err != nil {
+		return err
+	}
+
+	s := newStream()
+	id, _, err := c.open(s, "SYNC", remotePath, blockSize, signatureTable(sigs))
+
+	if err != nil {
+		return err
+	}
+
+	// Literals take up credit like the chunks of a read stream
+	r := &ReadStream{c: c, id: id, s: s}
+
+	for {
+		val, err := s.next()
+
+		if err != nil {
+			return err
+		}
+
+		var op delta.Op
+
+		switch v := val.(type) {
+		case *wire.Integer:
+			op.Block = int(v.Value)
+		case *wire.Blob:
+			op.Data = v.Data
+			r.grant(len(v.Data))
+		case *wire.Error:
+			return newError(v)
+		default:
+			if val == wire.Null {
+				return nil
+			}
+
+			r.Close()
+			return fmt.Errorf("flyclient: unexpected %s in sync stream", val.Name())
+		}
+
+		err = delta.Apply(w, base, blockSize, op)
+		release(val)
+
+		if err != nil {
+			r.Close()
+			return err
+		}
+	}
+}
+
+// Replaces a remote file with the contents of r, which is size bytes long,
+// only sending the parts that differ from the current remote file. Like with
+// Create, the file is only committed once the server verified it. The whole
+// file is sent when the server doesn't support deltas.
+func (c *Client) Patch(remotePath string, r io.Reader, size int64) error {
+	if !c.HasExtension("DELTA") {
+		w, err := c.Create(remotePath)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(w, r); err != nil {
+			w.Abort()
+			return err
+		}
+
+		return w.Close()
+	}
+
+	blockSize := syncBlockSize(size)
+	w, extra, err := c.create(remotePath, "DELTA", blockSize)
+
+	if err != nil {
+		return err
+	}
+
+	sigs, err := parseSignatures(extra)
+
+	if err != nil {
+		w.Abort()
+		return err
+	}
+
+	// Literals are only part of the file, so it's hashed as it's read
+	w.delta = true
+
+	err = delta.Diff(io.TeeReader(r, w.hash), blockSize, sigs, len(w.buf), func(op delta.Op) error {
+		if op.Data == nil {
+			return w.writeBlock(op.Block)
+		}
+
+		_, err := w.Write(op.Data)
+		return err
+	})
+
+	if err != nil {
+		w.Abort()
+		return err
+	}
+
+	return w.Close()
+}
+
+// Same heuristic as rsync: square root of the file size
+func syncBlockSize(size int64) int {
+	blockSize := int(math.Sqrt(float64(size)))
+
+	if blockSize < 700 {
+		return 700
+	}
+
+	if blockSize > 128*1024 {
+		return 128 * 1024
+	}
+
+	return blockSize
+}
+
+// Reads the signatures that follow the stream ID in the response to STREAM W
+func parseSignatures(values []wire.Value) ([]delta.Signature, error) {
+	var table *wire.Table
+
+	if len(values) == 1 {
+		table, _ = values[0].(*wire.Table)
+	}
+
+	if table == nil {
+		return nil, errors.New("flyclient: expected signatures in response to STREAM")
+	}
+
+	sigs := make([]delta.Signature, 0, table.RowCount)
+
+	for i := 0; i < table.RowCount; i++ {
+		row := table.Row(i)
+		weak, isInt := row[0].(*wire.Integer)
+		strong, isBlob := row[1].(*wire.Blob)
+
+		if !isInt || !isBlob || len(strong.Data) != delta.StrongSize {
+			return nil, fmt.Errorf("flyclient: invalid signature at row %d", i)
+		}
+
+		sig := delta.Signature{Weak: uint32(weak.Value)}
+		copy(sig.Strong[:], strong.Data)
+		sigs = append(sigs, sig)
+	}
+
+	return sigs, nil
+}
+
+func signatureTable(sigs []delta.Signature) *wire.Table {
+	table := &wire.Table{ColCount: 2}
+
+	for i := range sigs {
+		table.Add([]wire.Value{
+			wire.NewInteger(int64(sigs[i].Weak)),
+			wire.NewBlob(sigs[i].Strong[:]),
+		})
+	}
+
+	return table
+}
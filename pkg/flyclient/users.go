@@ -0,0 +1,215 @@
+package flyclient
+
+import (
+	"fmt"
+
+	"github.com/ngagnon/flybywire/internal/wire"
+)
+
+type User struct {
+	Username string
+	Chroot   string // empty when the user isn't chroot'ed
+	Admin    bool
+}
+
+// Access control policy, e.g. allow "bob" to read from "/home/bob"
+type Policy struct {
+	Name   string
+	Verb   string // ALLOW or DENY
+	Action string // R (read) or W (write)
+	Users  []string
+	Paths  []string
+}
+
+// Authenticates with a username and password
+func (c *Client) Auth(username string, password string) error {
+	return c.callOK("AUTH", "PWD", username, password)
+}
+
+// Authenticates with a token returned by Token, from another connection
+func (c *Client) AuthToken(token string) error {
+	return c.callOK("AUTH", "TOK", token)
+}
+
+// Returns a token that can be used to authenticate a new connection as the
+// current user, for the next 5 minutes
+func (c *Client) Token() (string, error) {
+	val, err := c.call("TOKEN")
+
+	if err != nil {
+		return "", err
+	}
+
+	return stringValue(val, "TOKEN")
+}
+
+// Returns the name of the authenticated user, empty when not authenticated
+func (c *Client) WhoAmI() (string, error) {
+	val, err := c.call("WHOAMI")
+
+	if err != nil || val == wire.Null {
+		return "", err
+	}
+
+	return stringValue(val, "WHOAMI")
+}
+
+func (c *Client) ListUsers() ([]string, error) {
+	val, err := c.call("LISTUSER")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stringArray(val, "LISTUSER")
+}
+
+func (c *Client) ShowUser(username string) (*User, error) {
+	val, err := c.call("SHOWUSER", username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := val.(*wire.Map)
+
+	if !ok {
+		return nil, fmt.Errorf("flyclient: unexpected %s in response to SHOWUSER", val.Name())
+	}
+
+	user := &User{Username: username}
+
+	if v, ok := m.Get("username"); ok {
+		if s, ok := v.(*wire.String); ok {
+			user.Username = s.Value
+		}
+	}
+
+	if v, ok := m.Get("chroot"); ok {
+		if s, ok := v.(*wire.String); ok {
+			user.Chroot = s.Value
+		}
+	}
+
+	if v, ok := m.Get("admin"); ok {
+		if b, ok := v.(*wire.Bool); ok {
+			user.Admin = b.Value
+		}
+	}
+
+	return user, nil
+}
+
+func (c *Client) AddUser(username string, password string) error {
+	return c.callOK("ADDUSER", username, password)
+}
+
+func (c *Client) RemoveUser(username string) error {
+	return c.callOK("RMUSER", username)
+}
+
+func (c *Client) SetPassword(username string, password string) error {
+	return c.callOK("SETPWD", username, password)
+}
+
+func (c *Client) SetAdmin(username string, admin bool) error {
+	return c.callOK("SETADM", username, admin)
+}
+
+// Restricts the user to the given folder (a path on the server, as seen by
+// an admin). Pass an empty path to remove the chroot.
+func (c *Client) Chroot(username string, folder string) error {
+	return c.callOK("CHROOT", username, folder)
+}
+
+func (c *Client) ListPolicies() ([]*Policy, error) {
+	val, err := c.call("LISTACP")
+
+	if err != nil {
+		return nil, err
+	}
+
+	table, ok := val.(*wire.Table)
+
+	if !ok {
+		return nil, fmt.Errorf("flyclient: unexpected %s in response to LISTACP", val.Name())
+	}
+
+	policies := make([]*Policy, 0, table.RowCount)
+
+	for i := 0; i < table.RowCount; i++ {
+		row := table.Row(i)
+
+		if len(row) != 5 {
+			return nil, fmt.Errorf("flyclient: expected 5 columns in LISTACP, got %d", len(row))
+		}
+
+		p := &Policy{}
+		var err error
+
+		if p.Name, err = stringValue(row[0], "LISTACP"); err != nil {
+			return nil, err
+		}
+
+		if p.Verb, err = stringValue(row[1], "LISTACP"); err != nil {
+			return nil, err
+		}
+
+		if p.Action, err = stringValue(row[2], "LISTACP"); err != nil {
+			return nil, err
+		}
+
+		if p.Users, err = stringArray(row[3], "LISTACP"); err != nil {
+			return nil, err
+		}
+
+		if p.Paths, err = stringArray(row[4], "LISTACP"); err != nil {
+			return nil, err
+		}
+
+		policies = append(policies, p)
+	}
+
+	return policies, nil
+}
+
+// Creates the policy, or replaces the policy with the same name
+func (c *Client) PutPolicy(p *Policy) error {
+	return c.callOK("PUTACP", p.Name, p.Verb, p.Action, p.Users, p.Paths)
+}
+
+func (c *Client) RemovePolicy(name string) error {
+	return c.callOK("RMACP", name)
+}
+
+func stringValue(val wire.Value, cmd string) (string, error) {
+	s, ok := val.(*wire.String)
+
+	if !ok {
+		return "", fmt.Errorf("flyclient: unexpected %s in response to %s", val.Name(), cmd)
+	}
+
+	return s.Value, nil
+}
+
+func stringArray(val wire.Value, cmd string) ([]string, error) {
+	arr, ok := val.(*wire.Array)
+
+	if !ok {
+		return nil, fmt.Errorf("flyclient: unexpected %s in response to %s", val.Name(), cmd)
+	}
+
+	values := make([]string, len(arr.Values))
+
+	for i, v := range arr.Values {
+		s, err := stringValue(v, cmd)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = s
+	}
+
+	return values, nil
+}
@@ -8,7 +8,7 @@ class SessionIO
             if s.respond_to?(:put)
                 s.put(@s)
             else
-                @s.puts "+#{s}\n"
+                Wire::String.new("#{s}").put(@s)
             end
         end
     end
@@ -21,6 +21,14 @@ class SessionIO
         @s.puts "_\n"
     end
 
+    def put_int(i)
+        @s.puts ":#{i}\n"
+    end
+
+    def put_string(str)
+        Wire::String.new(str).put(@s)
+    end
+
     def put_blob(blob)
         @s.puts "$#{blob.length}\n"
         @s.puts "#{blob}\n"
@@ -136,6 +144,13 @@ class Session < SessionIO
         end
     end
 
+    # Sends a command tagged with a request ID, without waiting for the response
+    def put_tagged(tag, name, *items)
+        name = TestSuite.get_command(name)
+        put_stream(tag)
+        Wire::Array.new([name].concat(items)).put(@s)
+    end
+
     def cmd!(name, *items)
         resp = cmd(name, *items)
 
@@ -189,13 +204,20 @@ class Session < SessionIO
         put_stream(id)
         put_null
 
-        50.times do
-            resp = cmd('LIST', name)
+        resp = get_frame(id)
 
-            if resp.is_a? Wire::Error
-                sleep 0.020
-            else
-                break
+        if resp.payload.is_a? Wire::Error
+            raise "unexpected error: #{resp.payload.code}: #{resp.payload.msg}"
+        end
+    end
+
+    # Skips responses until a frame for the given stream is received
+    def get_frame(id)
+        loop do
+            v = get_next
+
+            if (v.is_a? Wire::Frame) && v.id == id
+                return v
             end
         end
     end
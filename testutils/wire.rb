@@ -1,4 +1,26 @@
+require 'time'
+
 module Wire
+    def self.put_value(s, elem)
+        if elem.is_a? ::String
+            String.new(elem).put(s)
+        elsif elem.is_a? ::Integer
+            Integer.new(elem).put(s)
+        elsif elem.is_a? ::Float
+            Float.new(elem).put(s)
+        elsif elem.is_a? ::Time
+            Timestamp.new(elem).put(s)
+        elsif elem.is_a? ::Array
+            Array.new(elem).put(s)
+        elsif elem.is_a? ::Hash
+            Map.new(elem).put(s)
+        elsif !!elem == elem
+            Boolean.new(elem).put(s)
+        else
+            elem.put(s)
+        end
+    end
+
     class Integer
         attr_reader :value
 
@@ -11,6 +33,30 @@ module Wire
         end
     end
 
+    class Float
+        attr_reader :value
+
+        def initialize(value)
+            @value = value
+        end
+
+        def put(s)
+            s.puts ",#{@value}\n"
+        end
+    end
+
+    class Timestamp
+        attr_reader :value
+
+        def initialize(value)
+            @value = value
+        end
+
+        def put(s)
+            s.puts "^#{@value.utc.strftime('%Y-%m-%dT%H:%M:%S.%NZ')}\n"
+        end
+    end
+
     class Boolean
         attr_reader :value
 
@@ -38,23 +84,13 @@ module Wire
             s.puts "*#{@elems.length}\n"
 
             @elems.each do |elem|
-                if elem.is_a? ::String
-                    String.new(elem).put(s)
-                elsif elem.is_a? ::Integer
-                    Integer.new(elem).put(s)
-                elsif elem.is_a? ::Array
-                    Array.new(elem).put(s)
-                elsif !!elem == elem
-                    Boolean.new(elem).put(s)
-                else
-                    elem.put(s)
-                end
+                Wire.put_value(s, elem)
             end
         end
     end
 
     class Table
-        attr_reader :row_count
+        attr_accessor :row_count
         attr_reader :col_count
 
         def initialize(row_count, col_count)
@@ -76,7 +112,13 @@ module Wire
         end
  
         def put(s)
-            raise "not implemented"
+            s.puts "=#{@row_count},#{@col_count}\n"
+
+            @data.each do |row|
+                row.each do |elem|
+                    elem.put(s)
+                end
+            end
         end
 
         def rows()
@@ -109,8 +151,8 @@ module Wire
             s.puts "%#{@value.length}\n"
 
             @value.each do |key, value|
-                key.put(s)
-                value.put(s)
+                Wire.put_value(s, key)
+                Wire.put_value(s, value)
             end
         end
     end
@@ -123,7 +165,12 @@ module Wire
         end
 
         def put(s)
-            s.puts "+#{@value}\n"
+            if @value.include? "\n"
+                s.puts "~#{@value.bytesize}\n"
+                s.puts "#{@value}\n"
+            else
+                s.puts "+#{@value}\n"
+            end
         end
     end
 
@@ -160,6 +207,10 @@ module Wire
         end
     end
 
+    # Marks the end of a streamed array or table
+    class End
+    end
+
     class Frame
         attr_reader :id
         attr_reader :payload
@@ -212,26 +263,69 @@ module Wire
         elsif line.start_with? ':'
             line.delete_prefix!(':')
             return Integer.new(line.to_i)
+        elsif line.start_with? ','
+            line.delete_prefix!(',')
+            return Float.new(line.to_f)
+        elsif line.start_with? '^'
+            line.delete_prefix!('^')
+            return Timestamp.new(Time.iso8601(line))
         elsif line.start_with? '$'
             line.delete_prefix!("$")
             len = line.to_i
             str = s.read(len)
             gets_timeout(s, "\n", 1)
             return Blob.new(str)
+        elsif line.start_with? '~'
+            line.delete_prefix!("~")
+            len = line.to_i
+            str = s.read(len)
+            gets_timeout(s, "\n", 1)
+            return String.new(str.force_encoding('UTF-8'))
         elsif line.start_with? '*'
             line.delete_prefix!("*")
-            num_elems = line.to_i
             elems = []
 
-            num_elems.times do
-                elem = get_next(s)
-                elems.push(elem)
+            if line == '?'
+                # Streamed array: read until the end marker, unless the server gives up
+                loop do
+                    elem = get_next(s)
+                    break if elem.instance_of? End
+                    return elem if elem.instance_of? Error
+                    elems.push(elem)
+                end
+            else
+                line.to_i.times do
+                    elem = get_next(s)
+                    elems.push(elem)
+                end
             end
 
             return Array.new(elems)
         elsif line.start_with? '='
             line.delete_prefix!("=")
             elems = line.split(',')
+
+            if elems[0] == '?'
+                tab = Table.new(0, elems[1].to_i)
+
+                loop do
+                    first = get_next(s)
+                    break if first.instance_of? End
+                    return first if first.instance_of? Error
+
+                    row = [first]
+
+                    (tab.col_count - 1).times do
+                        row.push(get_next(s))
+                    end
+
+                    tab.push(row)
+                end
+
+                tab.row_count = tab.rows.length
+                return tab
+            end
+
             tab = Table.new(elems[0].to_i, elems[1].to_i)
 
             tab.row_count.times do
@@ -266,7 +360,8 @@ module Wire
             return Map.new(map)
         elsif line.start_with? '@'
             line.delete_prefix!("@")
-            stream_id = line.to_i
+            # Stream IDs are numeric, other tags are request IDs
+            stream_id = line.match?(/\A\d+\z/) ? line.to_i : line
             payload = get_next(s)
 
             return Frame.new(stream_id, payload)
@@ -274,6 +369,8 @@ module Wire
             return Boolean.new(line[1] == 't')
         elsif line == '_'
             return Null.new()
+        elsif line == '.'
+            return End.new()
         else
             raise 'get_next: illegal data type: ' + line[0]
         end